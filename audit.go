@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+var (
+    logFormat   string
+    logFilePath string
+)
+
+// addLogFlags registers the flags that pick where and how chaos is logged.
+func addLogFlags(fs *flag.FlagSet, defaultFile string) {
+    fs.StringVar(&logFormat, "log-format", "text", "Log format: text or json (JSON Lines)")
+    fs.StringVar(&logFilePath, "log-file", defaultFile, "File to log to, standard error when empty")
+}
+
+// auditOutput is where audit events are written in json mode, nil in text mode.
+var (
+    auditOutput io.Writer
+    auditMutex  sync.Mutex
+)
+
+// jsonLogWriter wraps the free form lines of the log package in JSON objects,
+// so the log file stays valid JSON Lines.
+type jsonLogWriter struct {
+    out io.Writer
+}
+
+func (w jsonLogWriter) Write(p []byte) (int, error) {
+    writeAuditLine(w.out, map[string]string{
+        "timestamp": time.Now().UTC().Format(time.RFC3339Nano),
+        "session":   sessionID(),
+        "message":   strings.TrimSuffix(string(p), "\n"),
+    })
+    return len(p), nil
+}
+
+// setupLogging points the log package at the log file in the configured format.
+// The returned file is nil when logging to standard error.
+func setupLogging() (*os.File, error) {
+    if logFormat != "text" && logFormat != "json" {
+        return nil, fmt.Errorf("invalid log format %q, must be text or json", logFormat)
+    }
+
+    var out io.Writer = os.Stderr
+    var file *os.File
+    if logFilePath != "" {
+        var err error
+        file, err = os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+        if err != nil {
+            return nil, err
+        }
+        out = file
+    }
+
+    if logFormat == "json" {
+        auditOutput = out
+        log.SetFlags(0)
+        log.SetOutput(jsonLogWriter{out: out})
+    } else {
+        log.SetOutput(out)
+    }
+    return file, nil
+}
+
+// AuditEvent is one attempt to eat a resource, as written to the log in json mode.
+type AuditEvent struct {
+    Timestamp time.Time `json:"timestamp"`
+    Session   string    `json:"session"`
+    Cluster   string    `json:"cluster"`
+    GVR       string    `json:"gvr"`
+    Namespace string    `json:"namespace"`
+    Name      string    `json:"name"`
+    UID       types.UID `json:"uid,omitempty"`
+    Outcome   string    `json:"outcome"`
+    Error     string    `json:"error,omitempty"`
+    DryRun    string    `json:"dry_run,omitempty"`
+    Score     int       `json:"score"`
+    User      string    `json:"user,omitempty"`
+}
+
+// deletionOutcome names the result of a delete for the audit log.
+func deletionOutcome(err error) string {
+    switch {
+    case err == nil:
+        return "deleted"
+    case errors.Is(err, errBudgetExhausted):
+        return "budget_exhausted"
+    case errors.Is(err, errClusterHurting):
+        return "cluster_hurting"
+    case errors.Is(err, errDisruptionBudget):
+        return "disruption_budget"
+    case errors.Is(err, errFoodGoneOff):
+        return "gone"
+    default:
+        return "failed"
+    }
+}
+
+// auditDeletion records the outcome of eating a resource. It is a no-op in text mode,
+// where the log lines written along the way already describe it.
+func auditDeletion(resourceInfo ResourceInfo, err error) {
+    if auditOutput == nil {
+        return
+    }
+
+    event := AuditEvent{
+        Timestamp: time.Now().UTC(),
+        Session:   sessionID(),
+        Cluster:   resourceInfo.Cluster,
+        GVR:       resourceInfo.Type,
+        Namespace: resourceInfo.Namespace,
+        Name:      resourceInfo.Name,
+        UID:       resourceInfo.UID,
+        Outcome:   deletionOutcome(err),
+        DryRun:    string(dryRun),
+        Score:     resourceInfo.Score,
+    }
+    if gvr, gvrErr := getResourceGVR(resourceInfo.Type); gvrErr == nil {
+        event.GVR = formatGroupVersionResource(gvr)
+    }
+    if err != nil {
+        event.Error = err.Error()
+    }
+    if cluster, clusterErr := getCluster(resourceInfo.Cluster); clusterErr == nil {
+        event.Cluster = cluster.Context
+        event.User = cluster.Identity()
+    }
+    writeAuditLine(auditOutput, event)
+}
+
+func writeAuditLine(out io.Writer, v interface{}) {
+    data, err := json.Marshal(v)
+    if err != nil {
+        return
+    }
+    auditMutex.Lock()
+    defer auditMutex.Unlock()
+    out.Write(append(data, '\n'))
+}
+
+// Identity returns the user the cluster's credentials authenticate as.
+// It is looked up once with a SelfSubjectReview.
+func (c *KubeCluster) Identity() string {
+    c.identityOnce.Do(func() {
+        review, err := c.clientset.AuthenticationV1().SelfSubjectReviews().Create(context.TODO(), &authenticationv1.SelfSubjectReview{}, metav1.CreateOptions{})
+        if err != nil {
+            return
+        }
+        c.identity = review.Status.UserInfo.Username
+    })
+    return c.identity
+}
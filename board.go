@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	tl "github.com/JoelOtter/termloop"
+	"golang.org/x/term"
+)
+
+// The board fills the terminal within these bounds, walls included.
+const (
+    defaultBoardWidth  = 80
+    defaultBoardHeight = 24
+    minBoardWidth      = 40
+    minBoardHeight     = 12
+    maxBoardWidth      = 240
+    maxBoardHeight     = 80
+    // hudLines are the lines below the board holding deletedPodText and clusterText
+    hudLines = 2
+)
+
+var (
+    boardFlag string
+    // fixedBoard is the size set with --board, zero when the board follows the terminal
+    fixedBoardWidth, fixedBoardHeight int
+)
+
+// addBoardFlag registers the flag that fixes the board size.
+func addBoardFlag(fs *flag.FlagSet) {
+    fs.StringVar(&boardFlag, "board", "", "Board size as WIDTHxHEIGHT, defaults to the size of the terminal")
+}
+
+// parseBoardFlag validates --board.
+func parseBoardFlag() error {
+    if boardFlag == "" {
+        return nil
+    }
+    if _, err := fmt.Sscanf(boardFlag, "%dx%d", &fixedBoardWidth, &fixedBoardHeight); err != nil {
+        return fmt.Errorf("invalid board size %q, use WIDTHxHEIGHT", boardFlag)
+    }
+    if fixedBoardWidth < minBoardWidth || fixedBoardHeight < minBoardHeight {
+        return fmt.Errorf("board size %q is smaller than %dx%d", boardFlag, minBoardWidth, minBoardHeight)
+    }
+    if fixedBoardWidth > maxBoardWidth || fixedBoardHeight > maxBoardHeight {
+        return fmt.Errorf("board size %q is larger than %dx%d", boardFlag, maxBoardWidth, maxBoardHeight)
+    }
+    return nil
+}
+
+// boardSize returns the board size for a terminal of the given size.
+func boardSize(terminalWidth, terminalHeight int) (int, int) {
+    if fixedBoardWidth > 0 {
+        return fixedBoardWidth, fixedBoardHeight
+    }
+    if terminalWidth <= 0 || terminalHeight <= 0 {
+        return defaultBoardWidth, defaultBoardHeight
+    }
+    width := min(max(terminalWidth, minBoardWidth), maxBoardWidth)
+    height := min(max(terminalHeight-hudLines, minBoardHeight), maxBoardHeight)
+    return width, height
+}
+
+// terminalSize returns the size of the terminal before the game takes it over, zero when unknown.
+func terminalSize() (int, int) {
+    width, height, err := term.GetSize(int(os.Stdout.Fd()))
+    if err != nil {
+        return 0, 0
+    }
+    return width, height
+}
+
+// layoutHUD moves the texts around the board after its size changed.
+func layoutHUD() {
+    deletedPodText.SetPosition(1, gameState.Height)
+    clusterText.SetPosition(1, gameState.Height+1)
+    // Recenter the overlays that are showing
+    overlays := map[*tl.Text]int{titleText: 0, pauseText: 0, finalScoreText: -1, restartText: 1}
+    for text, dy := range overlays {
+        if _, y := text.Position(); y >= 0 {
+            centerText(text, dy)
+        }
+    }
+}
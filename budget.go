@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// errBudgetExhausted is returned when eating a resource would exceed a blast radius budget.
+var errBudgetExhausted = errors.New("budget exhausted")
+
+// BudgetConfig caps how much the snake may eat. Zero means unlimited.
+type BudgetConfig struct {
+    Session         int `json:"session"`
+    PerNamespace    int `json:"per_namespace"`
+    PerResourceType int `json:"per_resource_type"`
+    // Namespaces and ResourceTypes override the per namespace and per resource type limits
+    Namespaces    map[string]int `json:"namespaces"`
+    ResourceTypes map[string]int `json:"resource_types"`
+    Window        WindowBudget   `json:"window"`
+}
+
+// WindowBudget caps deletions within a sliding time window, e.g. 5 per "10m".
+type WindowBudget struct {
+    Max    int    `json:"max"`
+    Period string `json:"period"`
+}
+
+// Budget tracks what has been eaten against the configured limits.
+type Budget struct {
+    mutex         sync.Mutex
+    session       int
+    namespaces    map[string]int
+    resourceTypes map[string]int
+    window        []time.Time
+}
+
+func NewBudget() *Budget {
+    return &Budget{namespaces: map[string]int{}, resourceTypes: map[string]int{}}
+}
+
+var budget = NewBudget()
+
+func (b *Budget) namespaceLimit(namespace string) int {
+    if limit, ok := gameConfig.Budgets.Namespaces[namespace]; ok {
+        return limit
+    }
+    return gameConfig.Budgets.PerNamespace
+}
+
+func (b *Budget) resourceTypeLimit(resourceType string) int {
+    for configured, limit := range gameConfig.Budgets.ResourceTypes {
+        if entry, ok := lookupResource(configured); ok && entry.singular == resourceType {
+            return limit
+        }
+    }
+    return gameConfig.Budgets.PerResourceType
+}
+
+func (b *Budget) windowPeriod() time.Duration {
+    period, _ := time.ParseDuration(gameConfig.Budgets.Window.Period)
+    return period
+}
+
+// pruneWindow drops deletions that have left the time window. Callers hold the mutex.
+func (b *Budget) pruneWindow() {
+    cutoff := time.Now().Add(-b.windowPeriod())
+    for len(b.window) > 0 && b.window[0].Before(cutoff) {
+        b.window = b.window[1:]
+    }
+}
+
+// exhausted returns why the resource may not be eaten, or an empty string. Callers hold the mutex.
+func (b *Budget) exhausted(resourceInfo ResourceInfo) string {
+    limits := gameConfig.Budgets
+    if limits.Session > 0 && b.session >= limits.Session {
+        return "session"
+    }
+    if limits.Window.Max > 0 {
+        b.pruneWindow()
+        if len(b.window) >= limits.Window.Max {
+            return "time window"
+        }
+    }
+    if limit := b.namespaceLimit(resourceInfo.Namespace); limit > 0 && b.namespaces[resourceInfo.Namespace] >= limit {
+        return "namespace " + resourceInfo.Namespace
+    }
+    if limit := b.resourceTypeLimit(resourceInfo.Type); limit > 0 && b.resourceTypes[resourceInfo.Type] >= limit {
+        return "resource type " + resourceInfo.Type
+    }
+    return ""
+}
+
+// Allows reports whether the resource can still be served as food.
+func (b *Budget) Allows(resourceInfo ResourceInfo) bool {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    return b.exhausted(resourceInfo) == ""
+}
+
+// Spend records the resource against every budget, or fails if one is used up.
+// The returned time identifies the spend in the time window for Refund.
+func (b *Budget) Spend(resourceInfo ResourceInfo) (time.Time, error) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    if reason := b.exhausted(resourceInfo); reason != "" {
+        return time.Time{}, fmt.Errorf("%w for %s", errBudgetExhausted, reason)
+    }
+    b.session++
+    b.namespaces[resourceInfo.Namespace]++
+    b.resourceTypes[resourceInfo.Type]++
+    spent := time.Now()
+    b.window = append(b.window, spent)
+    return spent, nil
+}
+
+// Refund gives back what Spend took when the deletion did not happen.
+// Only the window entry of that spend is removed, whatever was spent since.
+func (b *Budget) Refund(resourceInfo ResourceInfo, spent time.Time) {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    b.session--
+    b.namespaces[resourceInfo.Namespace]--
+    b.resourceTypes[resourceInfo.Type]--
+    for i, t := range b.window {
+        if t.Equal(spent) {
+            b.window = append(b.window[:i], b.window[i+1:]...)
+            break
+        }
+    }
+}
+
+// Summary describes the remaining budgets for the HUD. Namespaces and resource
+// types are listed once something was eaten from them or they have their own limit.
+func (b *Budget) Summary() string {
+    b.mutex.Lock()
+    defer b.mutex.Unlock()
+    limits := gameConfig.Budgets
+    var parts []string
+    if limits.Session > 0 {
+        parts = append(parts, fmt.Sprintf("%d/%d left", limits.Session-b.session, limits.Session))
+    }
+    if limits.Window.Max > 0 {
+        b.pruneWindow()
+        parts = append(parts, fmt.Sprintf("%d/%d per %s", limits.Window.Max-len(b.window), limits.Window.Max, limits.Window.Period))
+    }
+    for _, namespace := range budgetKeys(b.namespaces, limits.Namespaces) {
+        if limit := b.namespaceLimit(namespace); limit > 0 {
+            parts = append(parts, fmt.Sprintf("%s %d/%d", namespace, limit-b.namespaces[namespace], limit))
+        }
+    }
+    resourceTypes := map[string]int{}
+    for configured := range limits.ResourceTypes {
+        if entry, ok := lookupResource(configured); ok {
+            resourceTypes[entry.singular] = 0
+        }
+    }
+    for _, resourceType := range budgetKeys(b.resourceTypes, resourceTypes) {
+        if limit := b.resourceTypeLimit(resourceType); limit > 0 {
+            parts = append(parts, fmt.Sprintf("%s %d/%d", resourceType, limit-b.resourceTypes[resourceType], limit))
+        }
+    }
+    if len(parts) == 0 {
+        return ""
+    }
+    return "Budget: " + strings.Join(parts, ", ")
+}
+
+// budgetKeys returns the sorted keys that were spent from or have their own limit.
+func budgetKeys(spent, limits map[string]int) []string {
+    var keys []string
+    for key, count := range spent {
+        if _, ok := limits[key]; !ok && count > 0 {
+            keys = append(keys, key)
+        }
+    }
+    for key := range limits {
+        keys = append(keys, key)
+    }
+    sort.Strings(keys)
+    return keys
+}
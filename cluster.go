@@ -0,0 +1,176 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	tl "github.com/JoelOtter/termloop"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	listersv1 "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// KubeCluster holds the clients for one kubeconfig context.
+type KubeCluster struct {
+	Context string
+	Name    string
+	Server  string
+	// Color is used to draw food served from this cluster
+	Color tl.Attr
+
+	clientset       kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	restMapper      meta.RESTMapper
+	namespaceLister listersv1.NamespaceLister
+
+	// identity is the authenticated user, see Identity
+	identity     string
+	identityOnce sync.Once
+}
+
+// foodColors are handed out to clusters in multi-cluster mode. Green is taken by the snake.
+var foodColors = []tl.Attr{tl.ColorYellow, tl.ColorCyan, tl.ColorMagenta, tl.ColorBlue, tl.ColorRed, tl.ColorWhite}
+
+var (
+	clusters      []*KubeCluster
+	clustersMutex sync.Mutex
+
+	kubeconfigPath string
+	kubeContext    string
+)
+
+// addKubeFlags registers the flags that select the cluster to play against.
+func addKubeFlags(fs *flag.FlagSet) {
+	fs.StringVar(&kubeconfigPath, "kubeconfig", "", "Path to the kubeconfig file (defaults to $KUBECONFIG or ~/.kube/config)")
+	fs.StringVar(&kubeContext, "context", "", "Kubeconfig context to use (defaults to the current context)")
+}
+
+// initKubeClient connects to the context given with --context, every context
+// listed in the config, or the current context.
+func initKubeClient() {
+	contexts := gameConfig.Contexts
+	if kubeContext != "" || len(contexts) == 0 {
+		contexts = []string{kubeContext}
+	}
+
+	for _, contextName := range contexts {
+		if _, err := getCluster(contextName); err != nil {
+			log.Fatalf("Error connecting to context %q: %s\n", contextName, err.Error())
+		}
+	}
+
+	if len(clusters) > 1 {
+		for i, cluster := range clusters {
+			cluster.Color = foodColors[i%len(foodColors)]
+		}
+	}
+}
+
+// getCluster returns the cluster for a context, connecting to it the first
+// time it is asked for. An empty context is the first connected cluster, or
+// the current context.
+func getCluster(contextName string) (*KubeCluster, error) {
+	clustersMutex.Lock()
+	defer clustersMutex.Unlock()
+	for _, cluster := range clusters {
+		if cluster.Context == contextName || (contextName == "" && cluster == clusters[0]) {
+			return cluster, nil
+		}
+	}
+	cluster, err := connectCluster(contextName)
+	if err != nil {
+		return nil, err
+	}
+	clusters = append(clusters, cluster)
+	return cluster, nil
+}
+
+// connectedCluster returns the cluster for a context the game already plays
+// against, without connecting to new ones. An empty context is the first cluster.
+func connectedCluster(contextName string) (*KubeCluster, bool) {
+	clustersMutex.Lock()
+	defer clustersMutex.Unlock()
+	for _, cluster := range clusters {
+		if cluster.Context == contextName || (contextName == "" && cluster == clusters[0]) {
+			return cluster, true
+		}
+	}
+	return nil, false
+}
+
+func connectCluster(contextName string) (*KubeCluster, error) {
+	// Standard loading rules merge every file in $KUBECONFIG, falling back to ~/.kube/config
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfigPath
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: contextName}
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+
+	cluster := &KubeCluster{Context: "in-cluster", Name: "in-cluster"}
+	config, err := clientConfig.ClientConfig()
+	if err == nil {
+		rawConfig, err := clientConfig.RawConfig()
+		if err != nil {
+			return nil, err
+		}
+		current := rawConfig.CurrentContext
+		if contextName != "" {
+			current = contextName
+		}
+		// Without a matching context client-go picked up the in-cluster config
+		if kubeContextConfig, ok := rawConfig.Contexts[current]; ok {
+			cluster.Context = current
+			cluster.Name = kubeContextConfig.Cluster
+		}
+	} else if kubeconfigPath == "" && (contextName == "" || contextName == "in-cluster") {
+		log.Printf("Error building kubeconfig: %s\n", err.Error())
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+	cluster.Server = config.Host
+	config.Wrap(func(next http.RoundTripper) http.RoundTripper {
+		return &instrumentedTransport{cluster: cluster.Context, next: next}
+	})
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating clientset: %w", err)
+	}
+	cluster.clientset = clientset
+
+	cluster.dynamicClient, err = dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating dynamic client: %w", err)
+	}
+
+	cluster.restMapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(clientset.Discovery()))
+	return cluster, nil
+}
+
+func (c *KubeCluster) String() string {
+	return fmt.Sprintf("context %s, cluster %s at %s", c.Context, c.Name, c.Server)
+}
+
+// describeClusters names every cluster under attack for the HUD.
+func describeClusters() string {
+	if len(clusters) == 1 {
+		return clusters[0].String()
+	}
+	var names []string
+	for _, cluster := range clusters {
+		names = append(names, cluster.Context)
+	}
+	return "contexts " + strings.Join(names, ", ")
+}
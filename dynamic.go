@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+// DynamicResource serves any namespaced group/version/resource, including
+// custom resources, through the dynamic client.
+type DynamicResource struct {
+    client   dynamic.Interface
+    gvr      schema.GroupVersionResource
+    typeName string
+}
+
+func (d *DynamicResource) List(ctx context.Context, namespace string, opts metav1.ListOptions) ([]ResourceInfo, error) {
+    items, err := d.client.Resource(d.gvr).Namespace(namespace).List(ctx, opts)
+    if err != nil {
+        return nil, err
+    }
+    var results []ResourceInfo
+    for _, item := range items.Items {
+        if isEdible(&item) {
+            results = append(results, ResourceInfo{Name: item.GetName(), Namespace: item.GetNamespace(), Type: d.typeName, UID: item.GetUID(), ResourceVersion: item.GetResourceVersion()})
+        }
+    }
+    return results, nil
+}
+
+func (d *DynamicResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return d.client.Resource(d.gvr).Namespace(namespace).Delete(ctx, name, opts)
+}
+
+// formatGroupVersionResource is the inverse of parseGroupVersionResource.
+func formatGroupVersionResource(gvr schema.GroupVersionResource) string {
+    if gvr.Group == "" {
+        return gvr.Version + "/" + gvr.Resource
+    }
+    return gvr.Group + "/" + gvr.Version + "/" + gvr.Resource
+}
+
+// parseGroupVersionResource parses "group/version/resource", or "version/resource"
+// for the core group, as written in the resource_types config.
+func parseGroupVersionResource(resourceType string) (schema.GroupVersionResource, bool) {
+    parts := strings.Split(resourceType, "/")
+    switch len(parts) {
+    case 2:
+        return schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}, true
+    case 3:
+        return schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}, true
+    default:
+        return schema.GroupVersionResource{}, false
+    }
+}
+
+// registerDynamicResources resolves every group/version/resource in the config
+// with discovery and adds it to the resource registry.
+func registerDynamicResources() error {
+    for _, resourceType := range gameConfig.ResourceTypes {
+        if _, ok := lookupResource(resourceType); ok {
+            continue
+        }
+        gvr, ok := parseGroupVersionResource(resourceType)
+        if !ok {
+            return fmt.Errorf("unsupported resource type: %s", resourceType)
+        }
+        // Registering a built in type again would replace it, and lose eviction for pods
+        if builtIn, ok := resourceTypeForGVR(gvr); ok {
+            return fmt.Errorf("resource type %s is built in, use %s instead", resourceType, builtIn)
+        }
+
+        // Every cluster in play has to serve the resource
+        var apiResource *metav1.APIResource
+        for _, cluster := range clusters {
+            var err error
+            apiResource, err = discoverResource(cluster, gvr)
+            if err != nil {
+                return fmt.Errorf("resource %s on %s: %w", resourceType, cluster.Context, err)
+            }
+        }
+
+        registerDynamicResource(resourceType, gvr, apiResource.SingularName, apiResource.Kind)
+    }
+    return nil
+}
+
+func discoverResource(cluster *KubeCluster, gvr schema.GroupVersionResource) (*metav1.APIResource, error) {
+    resources, err := cluster.clientset.Discovery().ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+    if err != nil {
+        return nil, err
+    }
+
+    for i := range resources.APIResources {
+        apiResource := &resources.APIResources[i]
+        if apiResource.Name != gvr.Resource {
+            continue
+        }
+        if !apiResource.Namespaced {
+            return nil, fmt.Errorf("cluster scoped, only namespaced resources can be eaten")
+        }
+        if !contains(apiResource.Verbs, "list") || !contains(apiResource.Verbs, "delete") {
+            return nil, fmt.Errorf("list and delete are not supported")
+        }
+        return apiResource, nil
+    }
+    return nil, fmt.Errorf("not found on the server")
+}
+
+// registerDynamicResource adds gvr to the resource registry under name and
+// returns its ResourceInfo.Type.
+func registerDynamicResource(name string, gvr schema.GroupVersionResource, singular, kind string) string {
+    // Name the food like kubectl does (rollout.argoproj.io) so custom
+    // resources never clash with the built in singular names. Built in
+    // GVRs are refused by registerDynamicResources.
+    typeName := singular
+    if typeName == "" {
+        typeName = strings.ToLower(kind)
+    }
+    if gvr.Group != "" {
+        typeName += "." + gvr.Group
+    }
+
+    registerResource(name, typeName, gvr, func(c *KubeCluster) KubernetesResource {
+        return &DynamicResource{client: c.dynamicClient, gvr: gvr, typeName: typeName}
+    })
+    return typeName
+}
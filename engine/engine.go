@@ -0,0 +1,202 @@
+// Package engine holds the rules of the snake game, free of any terminal or
+// Kubernetes code. Frontends feed it input with Step and react to the events it returns.
+package engine
+
+// Point is a cell on the board.
+type Point struct {
+    X int `json:"x"`
+    Y int `json:"y"`
+}
+
+// Direction is where the snake is heading.
+type Direction string
+
+const (
+    Right Direction = "right"
+    Left  Direction = "left"
+    Up    Direction = "up"
+    Down  Direction = "down"
+)
+
+var opposites = map[Direction]Direction{Right: Left, Left: Right, Up: Down, Down: Up}
+
+// MoveEvery is the number of steps between two moves of the snake.
+const MoveEvery = 2
+
+// Size is the size of the board, walls included.
+type Size struct {
+    Width  int `json:"width"`
+    Height int `json:"height"`
+}
+
+// Food is a piece of food on the board. ID lets the frontend tell foods apart.
+type Food struct {
+    ID       int   `json:"id"`
+    Position Point `json:"position"`
+}
+
+// At reports whether p hits the food. X is checked in a wider range because
+// the snake moves two cells at a time horizontally.
+func (f Food) At(p Point) bool {
+    return (p.X == f.Position.X || p.X == f.Position.X-1 || p.X == f.Position.X+1) && p.Y == f.Position.Y
+}
+
+// State is everything there is to know about a game. Walls are the outermost cells.
+type State struct {
+    Width     int       `json:"width"`
+    Height    int       `json:"height"`
+    Snake     []Point   `json:"snake"`
+    Direction Direction `json:"direction"`
+    TickCount int       `json:"tick_count"`
+    Growth    int       `json:"growth"`
+    // Food is nil while no food is on the board
+    Food   *Food `json:"food,omitempty"`
+    Score  int   `json:"score"`
+    Paused bool  `json:"paused"`
+    Over   bool  `json:"over"`
+}
+
+// NewState starts a game on a width x height board with a three segment snake
+// heading right from x, y.
+func NewState(width, height, x, y int) State {
+    state := State{Width: width, Height: height, Direction: Right}
+    for i := 0; i < 3; i++ {
+        state.Snake = append(state.Snake, Point{X: x - i*2, Y: y})
+    }
+    return state
+}
+
+// Input is what happened since the last step.
+type Input struct {
+    // Direction turns the snake, empty keeps it going
+    Direction   Direction `json:"direction,omitempty"`
+    TogglePause bool      `json:"toggle_pause,omitempty"`
+    // PlaceFood puts food on the board, replacing any food already there.
+    // Positions outside the walls are moved inside.
+    PlaceFood *Food `json:"place_food,omitempty"`
+    // Bounced is the number of eaten foods that were refused, each costs a point
+    Bounced int `json:"bounced,omitempty"`
+    // Resize changes the board size on its own, without advancing the game
+    Resize *Size `json:"resize,omitempty"`
+}
+
+// EventType names what happened during a step.
+type EventType int
+
+const (
+    // FoodEaten is sent when the snake eats Event.Food
+    FoodEaten EventType = iota
+    // PauseToggled is sent when the game is paused or resumed, see State.Paused
+    PauseToggled
+    // GameOver is sent when the snake hits a wall or itself
+    GameOver
+)
+
+// Event is something the frontend may want to react to.
+type Event struct {
+    Type EventType
+    Food Food
+}
+
+// Step advances the game by one tick. The given state is not modified.
+func Step(state State, input Input) (State, []Event) {
+    if input.Resize != nil {
+        return state.resize(*input.Resize), nil
+    }
+    if state.Over {
+        return state, nil
+    }
+    if input.TogglePause {
+        state.Paused = !state.Paused
+        return state, []Event{{Type: PauseToggled}}
+    }
+    if state.Paused {
+        return state, nil
+    }
+
+    var events []Event
+    if input.PlaceFood != nil {
+        placed := *input.PlaceFood
+        // Food picked for a board that has shrunk since is moved inside the walls
+        placed.Position.X = min(max(placed.Position.X, 1), state.Width-2)
+        placed.Position.Y = min(max(placed.Position.Y, 1), state.Height-2)
+        state.Food = &placed
+    }
+    state.Score -= input.Bounced
+
+    if input.Direction != "" && opposites[input.Direction] != state.Direction {
+        state.Direction = input.Direction
+    }
+
+    state.TickCount++
+    if state.TickCount < MoveEvery {
+        return state, events
+    }
+    state.TickCount = 0
+
+    head := state.Snake[0]
+    switch state.Direction {
+    case Right:
+        head.X += 2
+    case Left:
+        head.X -= 2
+    case Up:
+        head.Y -= 1
+    case Down:
+        head.Y += 1
+    }
+
+    if state.Food != nil && state.Food.At(head) {
+        state.Growth++
+        state.Score++
+        events = append(events, Event{Type: FoodEaten, Food: *state.Food})
+        state.Food = nil
+    }
+
+    // Build a new body so the caller's state keeps its own
+    body := make([]Point, 0, len(state.Snake)+1)
+    body = append(body, head)
+    if state.Growth > 0 {
+        body = append(body, state.Snake...)
+        state.Growth--
+    } else {
+        body = append(body, state.Snake[:len(state.Snake)-1]...)
+    }
+    state.Snake = body
+
+    if state.hitsWall() || state.hitsSelf() {
+        state.Over = true
+        events = append(events, Event{Type: GameOver})
+    }
+    return state, events
+}
+
+// resize changes the board size, but never so far that the snake or the food
+// ends up in or beyond the walls.
+func (s State) resize(size Size) State {
+    points := s.Snake
+    if s.Food != nil {
+        points = append(points[:len(points):len(points)], s.Food.Position)
+    }
+    for _, p := range points {
+        size.Width = max(size.Width, p.X+2)
+        size.Height = max(size.Height, p.Y+2)
+    }
+    s.Width, s.Height = size.Width, size.Height
+    return s
+}
+
+func (s State) hitsWall() bool {
+    head := s.Snake[0]
+    return head.X < 1 || head.Y < 1 || head.X >= s.Width-1 || head.Y >= s.Height-1
+}
+
+func (s State) hitsSelf() bool {
+    head := s.Snake[0]
+    for _, segment := range s.Snake[1:] {
+        if head == segment {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"testing"
+)
+
+// run steps the game once per input and collects every event.
+func run(state State, inputs ...Input) (State, []Event) {
+    var events []Event
+    for _, input := range inputs {
+        var stepEvents []Event
+        state, stepEvents = Step(state, input)
+        events = append(events, stepEvents...)
+    }
+    return state, events
+}
+
+func hasEvent(events []Event, eventType EventType) bool {
+    for _, event := range events {
+        if event.Type == eventType {
+            return true
+        }
+    }
+    return false
+}
+
+func TestStep(t *testing.T) {
+    tests := []struct {
+        name   string
+        state  State
+        inputs []Input
+        check  func(t *testing.T, state State, events []Event)
+    }{
+        {
+            name:   "waits MoveEvery steps before moving",
+            state:  NewState(40, 20, 10, 5),
+            inputs: make([]Input, MoveEvery-1),
+            check: func(t *testing.T, state State, events []Event) {
+                if head := state.Snake[0]; head != (Point{X: 10, Y: 5}) {
+                    t.Errorf("head moved to %v before MoveEvery steps", head)
+                }
+            },
+        },
+        {
+            name:   "moves two cells right every MoveEvery steps",
+            state:  NewState(40, 20, 10, 5),
+            inputs: make([]Input, MoveEvery*2),
+            check: func(t *testing.T, state State, events []Event) {
+                want := []Point{{X: 14, Y: 5}, {X: 12, Y: 5}, {X: 10, Y: 5}}
+                if len(state.Snake) != len(want) {
+                    t.Fatalf("snake is %v, want %v", state.Snake, want)
+                }
+                for i := range want {
+                    if state.Snake[i] != want[i] {
+                        t.Fatalf("snake is %v, want %v", state.Snake, want)
+                    }
+                }
+            },
+        },
+        {
+            name:   "moves one cell down every MoveEvery steps",
+            state:  NewState(40, 20, 10, 5),
+            inputs: append([]Input{{Direction: Down}}, make([]Input, MoveEvery-1)...),
+            check: func(t *testing.T, state State, events []Event) {
+                if head := state.Snake[0]; head != (Point{X: 10, Y: 6}) {
+                    t.Errorf("head is %v, want {10 6}", head)
+                }
+            },
+        },
+        {
+            name:  "eating grows the snake and scores",
+            state: NewState(40, 20, 10, 5),
+            inputs: append([]Input{{PlaceFood: &Food{ID: 7, Position: Point{X: 12, Y: 5}}}},
+                make([]Input, MoveEvery*2-1)...),
+            check: func(t *testing.T, state State, events []Event) {
+                if state.Score != 1 {
+                    t.Errorf("score is %d, want 1", state.Score)
+                }
+                if len(state.Snake) != 4 {
+                    t.Errorf("snake has %d segments, want 4", len(state.Snake))
+                }
+                if state.Food != nil {
+                    t.Errorf("food %v is still on the board", state.Food)
+                }
+                if len(events) != 1 || events[0].Type != FoodEaten || events[0].Food.ID != 7 {
+                    t.Errorf("events are %v, want FoodEaten for food 7", events)
+                }
+            },
+        },
+        {
+            name:   "ignores the reverse direction",
+            state:  NewState(40, 20, 10, 5),
+            inputs: append([]Input{{Direction: Left}}, make([]Input, MoveEvery-1)...),
+            check: func(t *testing.T, state State, events []Event) {
+                if state.Direction != Right {
+                    t.Errorf("direction is %s, want %s", state.Direction, Right)
+                }
+                if head := state.Snake[0]; head != (Point{X: 12, Y: 5}) {
+                    t.Errorf("head is %v, want {12 5}", head)
+                }
+            },
+        },
+        {
+            name:   "hitting a wall ends the game",
+            state:  NewState(20, 10, 17, 5),
+            inputs: make([]Input, MoveEvery),
+            check: func(t *testing.T, state State, events []Event) {
+                if !state.Over || !hasEvent(events, GameOver) {
+                    t.Errorf("game is not over after hitting the wall at %v", state.Snake[0])
+                }
+            },
+        },
+        {
+            name: "hitting itself ends the game",
+            state: State{
+                Width:     40,
+                Height:    20,
+                Direction: Up,
+                Snake:     []Point{{X: 10, Y: 5}, {X: 12, Y: 5}, {X: 12, Y: 4}, {X: 10, Y: 4}, {X: 8, Y: 4}},
+            },
+            inputs: make([]Input, MoveEvery),
+            check: func(t *testing.T, state State, events []Event) {
+                if !state.Over || !hasEvent(events, GameOver) {
+                    t.Errorf("game is not over after hitting itself at %v", state.Snake[0])
+                }
+            },
+        },
+        {
+            name:   "nothing moves once the game is over",
+            state:  State{Width: 40, Height: 20, Direction: Right, Snake: []Point{{X: 10, Y: 5}}, Over: true},
+            inputs: make([]Input, MoveEvery*2),
+            check: func(t *testing.T, state State, events []Event) {
+                if head := state.Snake[0]; head != (Point{X: 10, Y: 5}) || len(events) != 0 {
+                    t.Errorf("head moved to %v with events %v after game over", head, events)
+                }
+            },
+        },
+        {
+            name:   "pausing freezes the snake",
+            state:  NewState(40, 20, 10, 5),
+            inputs: append([]Input{{TogglePause: true}}, make([]Input, MoveEvery*2)...),
+            check: func(t *testing.T, state State, events []Event) {
+                if !state.Paused {
+                    t.Error("game is not paused")
+                }
+                if head := state.Snake[0]; head != (Point{X: 10, Y: 5}) {
+                    t.Errorf("head moved to %v while paused", head)
+                }
+                if len(events) != 1 || events[0].Type != PauseToggled {
+                    t.Errorf("events are %v, want a single PauseToggled", events)
+                }
+            },
+        },
+        {
+            name:   "resuming moves the snake again",
+            state:  NewState(40, 20, 10, 5),
+            inputs: append([]Input{{TogglePause: true}, {TogglePause: true}}, make([]Input, MoveEvery)...),
+            check: func(t *testing.T, state State, events []Event) {
+                if state.Paused {
+                    t.Error("game is still paused")
+                }
+                if head := state.Snake[0]; head != (Point{X: 12, Y: 5}) {
+                    t.Errorf("head is %v, want {12 5}", head)
+                }
+            },
+        },
+        {
+            name:   "bounced food takes off points",
+            state:  State{Width: 40, Height: 20, Direction: Right, Snake: []Point{{X: 10, Y: 5}}, Score: 5},
+            inputs: []Input{{Bounced: 2}, {Bounced: 1}},
+            check: func(t *testing.T, state State, events []Event) {
+                if state.Score != 2 {
+                    t.Errorf("score is %d, want 2", state.Score)
+                }
+            },
+        },
+        {
+            name:   "placed food is kept inside the walls",
+            state:  NewState(40, 20, 10, 5),
+            inputs: []Input{{PlaceFood: &Food{Position: Point{X: 100, Y: -3}}}},
+            check: func(t *testing.T, state State, events []Event) {
+                if state.Food == nil || state.Food.Position != (Point{X: 38, Y: 1}) {
+                    t.Errorf("food is at %v, want {38 1}", state.Food)
+                }
+            },
+        },
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            state, events := run(test.state, test.inputs...)
+            test.check(t, state, events)
+        })
+    }
+}
+
+func TestStepResize(t *testing.T) {
+    food := &Food{Position: Point{X: 30, Y: 15}}
+    tests := []struct {
+        name       string
+        food       *Food
+        size       Size
+        wantWidth  int
+        wantHeight int
+    }{
+        {name: "grows", size: Size{Width: 80, Height: 40}, wantWidth: 80, wantHeight: 40},
+        {name: "shrinks down to the snake", size: Size{Width: 5, Height: 5}, wantWidth: 12, wantHeight: 7},
+        {name: "shrinks down to the food", food: food, size: Size{Width: 5, Height: 5}, wantWidth: 32, wantHeight: 17},
+        {name: "shrinks when nothing is in the way", food: food, size: Size{Width: 35, Height: 18}, wantWidth: 35, wantHeight: 18},
+    }
+
+    for _, test := range tests {
+        t.Run(test.name, func(t *testing.T) {
+            state := NewState(40, 20, 10, 5)
+            state.Food = test.food
+            state.TickCount = 1
+
+            resized, events := Step(state, Input{Resize: &test.size})
+            if resized.Width != test.wantWidth || resized.Height != test.wantHeight {
+                t.Errorf("board is %dx%d, want %dx%d", resized.Width, resized.Height, test.wantWidth, test.wantHeight)
+            }
+            if len(events) != 0 {
+                t.Errorf("resize sent events %v", events)
+            }
+            if resized.TickCount != state.TickCount || resized.Snake[0] != state.Snake[0] {
+                t.Error("resize advanced the game")
+            }
+            for _, p := range resized.Snake {
+                if p.X >= resized.Width-1 || p.Y >= resized.Height-1 {
+                    t.Errorf("snake segment %v is in or beyond the walls", p)
+                }
+            }
+        })
+    }
+}
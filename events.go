@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os/user"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// eventReason marks events recorded for eaten resources.
+const eventReason = "SerpentAte"
+
+// playerName names whoever is playing, preferring the identity the cluster knows them by.
+func playerName(cluster *KubeCluster) string {
+    if identity := cluster.Identity(); identity != "" {
+        return identity
+    }
+    if current, err := user.Current(); err == nil {
+        return current.Username
+    }
+    return "someone"
+}
+
+// recordEatenEvent records an event on the eaten resource's namespace, so the
+// chaos is not mistaken for a real incident. The event is created before
+// returning, so none are lost when the game exits.
+func recordEatenEvent(cluster *KubeCluster, resourceInfo ResourceInfo) {
+    gvr, err := getResourceGVR(resourceInfo.Type)
+    if err != nil {
+        return
+    }
+    reference := &v1.ObjectReference{
+        APIVersion: gvr.GroupVersion().String(),
+        Namespace:  resourceInfo.Namespace,
+        Name:       resourceInfo.Name,
+        UID:        resourceInfo.UID,
+    }
+    if kind, err := cluster.restMapper.KindFor(gvr); err == nil {
+        reference.Kind = kind.Kind
+    }
+    now := metav1.Now()
+    event := &v1.Event{
+        ObjectMeta:     metav1.ObjectMeta{GenerateName: resourceInfo.Name + ".", Namespace: resourceInfo.Namespace},
+        InvolvedObject: *reference,
+        Reason:         eventReason,
+        Message:        fmt.Sprintf("%s %s was eaten by serpent, played by %s", resourceInfo.Type, resourceInfo.Name, playerName(cluster)),
+        Type:           v1.EventTypeWarning,
+        Source:         v1.EventSource{Component: "serpent"},
+        FirstTimestamp: now,
+        LastTimestamp:  now,
+        Count:          1,
+    }
+    if _, err := cluster.clientset.CoreV1().Events(resourceInfo.Namespace).Create(context.TODO(), event, metav1.CreateOptions{}); err != nil {
+        log.Printf("Error recording event for %s %s in namespace %s on %s: %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, cluster.Context, err)
+    }
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+)
+
+const runnerName = "serpent"
+
+// rbacRules returns the least privilege rules the headless runner needs for
+// the configured resource types: list and watch to serve food, get to
+// snapshot it and delete (or evict, for pods) to eat it.
+func rbacRules() ([]rbacv1.PolicyRule, error) {
+    var rules []rbacv1.PolicyRule
+    for _, resourceType := range gameConfig.ResourceTypes {
+        var gvr schema.GroupVersionResource
+        if entry, ok := lookupResource(resourceType); ok {
+            gvr = entry.gvr
+        } else if parsed, ok := parseGroupVersionResource(resourceType); ok {
+            gvr = parsed
+        } else {
+            return nil, fmt.Errorf("unsupported resource type: %s", resourceType)
+        }
+
+        verbs := []string{"list", "watch", "get", "delete"}
+        if gvr.Group == "" && gvr.Resource == "pods" && gameConfig.PodDeletion == "evict" {
+            verbs = []string{"list", "watch", "get"}
+            rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods/eviction"}, Verbs: []string{"create"}})
+        }
+        rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{gvr.Group}, Resources: []string{gvr.Resource}, Verbs: verbs})
+    }
+    // Every deletion is announced with an event
+    rules = append(rules, rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"events"}, Verbs: []string{"create", "patch"}})
+
+    // Owners are read to show them, and eaten instead of the food in root mode
+    if gameConfig.Owners != "none" {
+        verbs := []string{"get"}
+        if gameConfig.Owners == "root" {
+            verbs = []string{"get", "delete"}
+        }
+        for _, owner := range ownerRules {
+            rules = append(rules, rbacv1.PolicyRule{APIGroups: owner.APIGroups, Resources: owner.Resources, Verbs: verbs})
+        }
+    }
+    return rules, nil
+}
+
+// healthRules returns the rules the Kubernetes health checks need, by the
+// namespace they probe. An empty namespace probes every namespace.
+func healthRules() map[string][]rbacv1.PolicyRule {
+    rules := map[string][]rbacv1.PolicyRule{}
+    for _, check := range gameConfig.HealthChecks {
+        switch {
+        case check.Deployment != nil:
+            rules[check.Deployment.Namespace] = append(rules[check.Deployment.Namespace], rbacv1.PolicyRule{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, ResourceNames: []string{check.Deployment.Name}, Verbs: []string{"get"}})
+        case check.PodReadiness != nil:
+            rules[check.PodReadiness.Namespace] = append(rules[check.PodReadiness.Namespace], rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"list"}})
+        }
+    }
+    return rules
+}
+
+// ownerRules are the built in controllers that own other resources
+var ownerRules = []rbacv1.PolicyRule{
+    {APIGroups: []string{"apps"}, Resources: []string{"replicasets", "deployments", "statefulsets", "daemonsets"}},
+    {APIGroups: []string{"batch"}, Resources: []string{"jobs", "cronjobs"}},
+}
+
+// runnerManifests builds the ServiceAccount, RBAC and, when an image is given,
+// the ConfigMap and Deployment for a headless runner in runnerNamespace.
+func runnerManifests(runnerNamespace, image string, interval time.Duration) ([]interface{}, error) {
+    rules, err := rbacRules()
+    if err != nil {
+        return nil, err
+    }
+
+    labels := map[string]string{"app.kubernetes.io/name": runnerName}
+    meta := func(name, namespace string) metav1.ObjectMeta {
+        return metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels}
+    }
+    subjects := []rbacv1.Subject{{Kind: rbacv1.ServiceAccountKind, Name: runnerName, Namespace: runnerNamespace}}
+
+    // The runner must never eat itself
+    namespaceMeta := meta(runnerNamespace, "")
+    namespaceMeta.Labels = map[string]string{"app.kubernetes.io/name": runnerName, immuneKey: "true"}
+
+    manifests := []interface{}{
+        &v1.Namespace{
+            TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+            ObjectMeta: namespaceMeta,
+        },
+        &v1.ServiceAccount{
+            TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+            ObjectMeta: meta(runnerName, runnerNamespace),
+        },
+        // Namespaces are always watched cluster wide to honor immunity markers
+        &rbacv1.ClusterRole{
+            TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+            ObjectMeta: meta(runnerName+"-namespaces", ""),
+            Rules:      []rbacv1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"list", "watch", "get"}}},
+        },
+        &rbacv1.ClusterRoleBinding{
+            TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+            ObjectMeta: meta(runnerName+"-namespaces", ""),
+            RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: runnerName + "-namespaces"},
+            Subjects:   subjects,
+        },
+    }
+
+    // Scope to the included namespaces, a cluster wide role is only used when none are listed
+    if len(gameConfig.Namespaces.Include) == 0 {
+        manifests = append(manifests,
+            &rbacv1.ClusterRole{
+                TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+                ObjectMeta: meta(runnerName, ""),
+                Rules:      rules,
+            },
+            &rbacv1.ClusterRoleBinding{
+                TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+                ObjectMeta: meta(runnerName, ""),
+                RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: runnerName},
+                Subjects:   subjects,
+            })
+    }
+    for _, namespace := range gameConfig.Namespaces.Include {
+        manifests = append(manifests,
+            &rbacv1.Role{
+                TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+                ObjectMeta: meta(runnerName, namespace),
+                Rules:      rules,
+            },
+            &rbacv1.RoleBinding{
+                TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+                ObjectMeta: meta(runnerName, namespace),
+                RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: runnerName},
+                Subjects:   subjects,
+            })
+    }
+
+    // Health checks probe their own namespaces, which need not be played in
+    health := healthRules()
+    namespaces := make([]string, 0, len(health))
+    for namespace := range health {
+        namespaces = append(namespaces, namespace)
+    }
+    sort.Strings(namespaces)
+    for _, namespace := range namespaces {
+        if namespace == metav1.NamespaceAll {
+            manifests = append(manifests,
+                &rbacv1.ClusterRole{
+                    TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+                    ObjectMeta: meta(runnerName+"-health", ""),
+                    Rules:      health[namespace],
+                },
+                &rbacv1.ClusterRoleBinding{
+                    TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+                    ObjectMeta: meta(runnerName+"-health", ""),
+                    RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: runnerName + "-health"},
+                    Subjects:   subjects,
+                })
+            continue
+        }
+        manifests = append(manifests,
+            &rbacv1.Role{
+                TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+                ObjectMeta: meta(runnerName+"-health", namespace),
+                Rules:      health[namespace],
+            },
+            &rbacv1.RoleBinding{
+                TypeMeta:   metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+                ObjectMeta: meta(runnerName+"-health", namespace),
+                RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: runnerName + "-health"},
+                Subjects:   subjects,
+            })
+    }
+
+    if image == "" {
+        return manifests, nil
+    }
+
+    config, err := json.MarshalIndent(runnerConfig(), "", "    ")
+    if err != nil {
+        return nil, err
+    }
+    replicas := int32(1)
+    runAsNonRoot := true
+    readOnlyRootFilesystem := true
+    manifests = append(manifests,
+        &v1.ConfigMap{
+            TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+            ObjectMeta: meta(runnerName+"-config", runnerNamespace),
+            Data:       map[string]string{"config.json": string(config)},
+        },
+        &appsv1.Deployment{
+            TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+            ObjectMeta: meta(runnerName, runnerNamespace),
+            Spec: appsv1.DeploymentSpec{
+                Replicas: &replicas,
+                Selector: &metav1.LabelSelector{MatchLabels: labels},
+                Template: v1.PodTemplateSpec{
+                    ObjectMeta: metav1.ObjectMeta{Labels: labels},
+                    Spec: v1.PodSpec{
+                        ServiceAccountName: runnerName,
+                        Containers: []v1.Container{{
+                            Name:  runnerName,
+                            Image: image,
+                            Args: []string{
+                                "run",
+                                "--config", "/etc/serpent/config.json",
+                                "--session-dir", "/var/lib/serpent/sessions",
+                                "--interval", interval.String(),
+                            },
+                            SecurityContext: &v1.SecurityContext{
+                                RunAsNonRoot:           &runAsNonRoot,
+                                ReadOnlyRootFilesystem: &readOnlyRootFilesystem,
+                            },
+                            VolumeMounts: []v1.VolumeMount{
+                                {Name: "config", MountPath: "/etc/serpent"},
+                                {Name: "sessions", MountPath: "/var/lib/serpent/sessions"},
+                            },
+                        }},
+                        Volumes: []v1.Volume{
+                            {Name: "config", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{LocalObjectReference: v1.LocalObjectReference{Name: runnerName + "-config"}}}},
+                            {Name: "sessions", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+                        },
+                    },
+                },
+            },
+        })
+    return manifests, nil
+}
+
+// runnerConfig returns the config for the runner Deployment. Inside the cluster
+// there is no kubeconfig, so the runner plays against the in-cluster context only.
+func runnerConfig() Config {
+    config := gameConfig
+    config.Contexts = nil
+    // Health checks run against the in-cluster context as well
+    config.HealthChecks = make([]HealthCheckConfig, len(gameConfig.HealthChecks))
+    for i, check := range gameConfig.HealthChecks {
+        check.Context = ""
+        config.HealthChecks[i] = check
+    }
+    if len(config.Protection.AllowedServers) > 0 {
+        log.Printf("Dropping protection.allowed_servers from the runner config, the runner uses the in-cluster API server\n")
+        config.Protection.AllowedServers = nil
+    }
+    return config
+}
+
+// runGenerate implements the "serpent generate" subcommand.
+func runGenerate(args []string) {
+    generateFlags := flag.NewFlagSet("generate", flag.ExitOnError)
+    runnerNamespace := generateFlags.String("runner-namespace", runnerName, "Namespace the runner is deployed to")
+    image := generateFlags.String("image", "", "Container image for the runner Deployment, only RBAC is generated when empty")
+    interval := generateFlags.Duration("interval", time.Minute, "Time between deletions for the runner")
+    addConfigFlags(generateFlags)
+    generateFlags.Parse(args)
+
+    loadConfig()
+
+    manifests, err := runnerManifests(*runnerNamespace, *image, *interval)
+    if err != nil {
+        log.Fatalf("Failed to generate manifests: %s", err)
+    }
+    for i, manifest := range manifests {
+        data, err := yaml.Marshal(manifest)
+        if err != nil {
+            log.Fatalf("Failed to generate manifests: %s", err)
+        }
+        if i > 0 {
+            fmt.Println("---")
+        }
+        os.Stdout.Write(data)
+    }
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HealthCheckConfig is a steady state probe. Exactly one of HTTP, Deployment
+// and PodReadiness is set.
+type HealthCheckConfig struct {
+    Name string `json:"name"`
+    // Context is the cluster checked by Kubernetes probes, empty is the first cluster
+    Context      string             `json:"context"`
+    HTTP         *HTTPCheck         `json:"http"`
+    Deployment   *DeploymentCheck   `json:"deployment"`
+    PodReadiness *PodReadinessCheck `json:"pod_readiness"`
+}
+
+// HTTPCheck passes when a GET of URL returns a 2xx status.
+type HTTPCheck struct {
+    URL string `json:"url"`
+}
+
+// DeploymentCheck passes when the Deployment has at least MinAvailable available replicas.
+type DeploymentCheck struct {
+    Namespace    string `json:"namespace"`
+    Name         string `json:"name"`
+    MinAvailable int32  `json:"min_available"`
+}
+
+// PodReadinessCheck passes when at least MinRatio of the matching pods in Namespace are ready.
+type PodReadinessCheck struct {
+    Namespace     string  `json:"namespace"`
+    LabelSelector string  `json:"label_selector"`
+    MinRatio      float64 `json:"min_ratio"`
+}
+
+func (c HealthCheckConfig) validate() error {
+    probes := 0
+    for _, set := range []bool{c.HTTP != nil, c.Deployment != nil, c.PodReadiness != nil} {
+        if set {
+            probes++
+        }
+    }
+    if c.Name == "" || probes != 1 {
+        return fmt.Errorf("health check %q needs a name and exactly one of http, deployment or pod_readiness", c.Name)
+    }
+    return nil
+}
+
+// errClusterHurting is returned when eating a resource while a health check fails.
+var errClusterHurting = errors.New("cluster is hurting")
+
+var healthHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// probe returns nil when the check passes.
+func (c HealthCheckConfig) probe(ctx context.Context) error {
+    if c.HTTP != nil {
+        request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.HTTP.URL, nil)
+        if err != nil {
+            return err
+        }
+        response, err := healthHTTPClient.Do(request)
+        if err != nil {
+            return err
+        }
+        response.Body.Close()
+        if response.StatusCode < 200 || response.StatusCode > 299 {
+            return fmt.Errorf("GET %s returned %s", c.HTTP.URL, response.Status)
+        }
+        return nil
+    }
+
+    cluster, err := c.cluster()
+    if err != nil {
+        return err
+    }
+
+    if c.Deployment != nil {
+        deployment, err := cluster.clientset.AppsV1().Deployments(c.Deployment.Namespace).Get(ctx, c.Deployment.Name, metav1.GetOptions{})
+        if err != nil {
+            return err
+        }
+        if deployment.Status.AvailableReplicas < c.Deployment.MinAvailable {
+            return fmt.Errorf("deployment %s has %d available replicas, wants %d", c.Deployment.Name, deployment.Status.AvailableReplicas, c.Deployment.MinAvailable)
+        }
+        return nil
+    }
+
+    pods, err := cluster.clientset.CoreV1().Pods(c.PodReadiness.Namespace).List(ctx, metav1.ListOptions{LabelSelector: c.PodReadiness.LabelSelector})
+    if err != nil {
+        return err
+    }
+    if len(pods.Items) == 0 {
+        return fmt.Errorf("no pods in namespace %s", c.PodReadiness.Namespace)
+    }
+    ready := 0
+    for _, pod := range pods.Items {
+        if isPodReady(&pod) {
+            ready++
+        }
+    }
+    ratio := float64(ready) / float64(len(pods.Items))
+    if ratio < c.PodReadiness.MinRatio {
+        return fmt.Errorf("%d of %d pods ready in namespace %s, wants %.0f%%", ready, len(pods.Items), c.PodReadiness.Namespace, c.PodReadiness.MinRatio*100)
+    }
+    return nil
+}
+
+// cluster returns the cluster a Kubernetes probe checks. Probes only check
+// clusters the game plays against, so they never add clusters of their own.
+func (c HealthCheckConfig) cluster() (*KubeCluster, error) {
+    cluster, ok := connectedCluster(c.Context)
+    if !ok {
+        return nil, fmt.Errorf("health check %s checks context %q, which is not played against", c.Name, c.Context)
+    }
+    return cluster, nil
+}
+
+func isPodReady(pod *v1.Pod) bool {
+    for _, condition := range pod.Status.Conditions {
+        if condition.Type == v1.PodReady {
+            return condition.Status == v1.ConditionTrue
+        }
+    }
+    return false
+}
+
+// healthState holds the probes that are currently failing, by name.
+type healthState struct {
+    mutex   sync.Mutex
+    failing map[string]string
+}
+
+var health = &healthState{failing: map[string]string{}}
+
+// clusterHealthy reports whether every steady state probe passes.
+func clusterHealthy() bool {
+    health.mutex.Lock()
+    defer health.mutex.Unlock()
+    return len(health.failing) == 0
+}
+
+// failingProbes names the probes that are currently failing.
+func failingProbes() []string {
+    health.mutex.Lock()
+    defer health.mutex.Unlock()
+    var names []string
+    for name := range health.failing {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// evaluateHealthChecks runs every probe once and logs transitions.
+func evaluateHealthChecks() {
+    for _, check := range gameConfig.HealthChecks {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        err := check.probe(ctx)
+        cancel()
+
+        health.mutex.Lock()
+        _, wasFailing := health.failing[check.Name]
+        if err != nil {
+            health.failing[check.Name] = err.Error()
+            if !wasFailing {
+                log.Printf("Health check %s failed, pausing chaos: %s\n", check.Name, err)
+            }
+        } else {
+            delete(health.failing, check.Name)
+            if wasFailing {
+                log.Printf("Health check %s recovered\n", check.Name)
+            }
+        }
+        health.mutex.Unlock()
+    }
+}
+
+// startHealthChecks evaluates the probes once, then keeps evaluating them in the background.
+func startHealthChecks() {
+    if len(gameConfig.HealthChecks) == 0 {
+        return
+    }
+    for _, check := range gameConfig.HealthChecks {
+        if check.HTTP != nil {
+            continue
+        }
+        if _, err := check.cluster(); err != nil {
+            log.Fatal(err)
+        }
+    }
+    evaluateHealthChecks()
+
+    interval, _ := time.ParseDuration(gameConfig.HealthInterval)
+    go func() {
+        for range time.Tick(interval) {
+            evaluateHealthChecks()
+        }
+    }()
+}
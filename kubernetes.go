@@ -3,38 +3,61 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
-	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 )
 
-var resourceInfoQueue = make(chan ResourceInfo, 100)
+// resourceInfoQueue holds a few resources with their owners resolved, ready to be served
+var resourceInfoQueue = make(chan ResourceInfo, 5)
 
 func fetchResources() {
     for {
         resourceInfo, err := getRandomResourceInfo()
         if err != nil {
             log.Printf("Error fetching resource info: %s\n", err)
-        } else {
-            resourceInfoQueue <- resourceInfo
+            time.Sleep(1 * time.Second)
+            continue
+        }
+        resourceInfoQueue <- resourceInfo
+    }
+}
+
+// nextResourceInfo takes the next queued resource that is still in the candidate pool.
+// Nothing is served while a health check fails.
+func nextResourceInfo() (ResourceInfo, bool) {
+    if !clusterHealthy() {
+        return ResourceInfo{}, false
+    }
+    for {
+        select {
+        case resourceInfo := <-resourceInfoQueue:
+            if current, ok := candidates.Get(resourceInfo.candidate); ok && current.UID == resourceInfo.candidateUID && budget.Allows(resourceInfo) {
+                return resourceInfo, true
+            }
+        default:
+            return ResourceInfo{}, false
         }
-        time.Sleep(1 * time.Second)
     }
 }
 
 type KubernetesResource interface {
     List(ctx context.Context, namespace string, opts metav1.ListOptions) ([]ResourceInfo, error)
-    Delete(ctx context.Context, namespace, name string) error
+    Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error
 }
 
 // Resource types supported
@@ -51,53 +74,68 @@ type KubernetesResource interface {
 // Ingresses
 
 type PodResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type ReplicaSetResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type DeploymentResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type StatefulSetResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type ServiceResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type DaemonSetResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type SecretResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type ConfigMapResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type JobResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type CronJobResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type IngressResource struct {
-    clientset *kubernetes.Clientset
+    clientset kubernetes.Interface
 }
 
 type ResourceInfo struct {
     Name      string
     Namespace string
     Type      string
+    // Cluster is the kubeconfig context the resource lives in
+    Cluster string
+    // UID and ResourceVersion identify the exact object served, so a newer
+    // object with the same name is never eaten by mistake
+    UID             types.UID
+    ResourceVersion string
+    // Owners is the controller chain from the immediate owner up to the root
+    Owners []OwnerInfo
+    // EatenVia is the resource the food was picked for when its root owner is eaten instead
+    EatenVia string
+    // Score is the player's score when the resource was eaten, for the audit log
+    Score int
+    // candidate and candidateUID identify the pool entry this food was picked from
+    candidate    string
+    candidateUID types.UID
 }
 
 func (p *PodResource) List(ctx context.Context, namespace string, opts metav1.ListOptions) ([]ResourceInfo, error) {
@@ -107,8 +145,8 @@ func (p *PodResource) List(ctx context.Context, namespace string, opts metav1.Li
     }
     var results []ResourceInfo
     for _, pod := range pods.Items {
-        if !isCriticalPod(pod) {
-            results = append(results, ResourceInfo{Name: pod.Name, Namespace: pod.Namespace, Type: "pod"})
+        if !isCriticalPod(&pod) && isEdible(&pod) {
+            results = append(results, ResourceInfo{Name: pod.Name, Namespace: pod.Namespace, Type: "pod", UID: pod.UID, ResourceVersion: pod.ResourceVersion})
         }
     }
     return results, nil
@@ -121,7 +159,9 @@ func (r *ReplicaSetResource) List(ctx context.Context, namespace string, opts me
     }
     var results []ResourceInfo
     for _, rs := range replicasets.Items {
-        results = append(results, ResourceInfo{Name: rs.Name, Namespace: rs.Namespace, Type: "replicaset"})
+        if isEdible(&rs) {
+            results = append(results, ResourceInfo{Name: rs.Name, Namespace: rs.Namespace, Type: "replicaset", UID: rs.UID, ResourceVersion: rs.ResourceVersion})
+        }
     }
     return results, nil
 }
@@ -133,7 +173,9 @@ func (d *DeploymentResource) List(ctx context.Context, namespace string, opts me
     }
     var results []ResourceInfo
     for _, deployment := range deployments.Items {
-        results = append(results, ResourceInfo{Name: deployment.Name, Namespace: deployment.Namespace, Type: "deployment"})
+        if isEdible(&deployment) {
+            results = append(results, ResourceInfo{Name: deployment.Name, Namespace: deployment.Namespace, Type: "deployment", UID: deployment.UID, ResourceVersion: deployment.ResourceVersion})
+        }
     }
     return results, nil
 }
@@ -145,7 +187,9 @@ func (s *StatefulSetResource) List(ctx context.Context, namespace string, opts m
     }
     var results []ResourceInfo
     for _, ss := range statefulSets.Items {
-        results = append(results, ResourceInfo{Name: ss.Name, Namespace: ss.Namespace, Type: "statefulset"})
+        if isEdible(&ss) {
+            results = append(results, ResourceInfo{Name: ss.Name, Namespace: ss.Namespace, Type: "statefulset", UID: ss.UID, ResourceVersion: ss.ResourceVersion})
+        }
     }
     return results, nil
 }
@@ -157,7 +201,9 @@ func (s *ServiceResource) List(ctx context.Context, namespace string, opts metav
     }
     var results []ResourceInfo
     for _, svc := range services.Items {
-        results = append(results, ResourceInfo{Name: svc.Name, Namespace: svc.Namespace, Type: "service"})
+        if isEdible(&svc) {
+            results = append(results, ResourceInfo{Name: svc.Name, Namespace: svc.Namespace, Type: "service", UID: svc.UID, ResourceVersion: svc.ResourceVersion})
+        }
     }
     return results, nil
 }
@@ -169,7 +215,9 @@ func (d *DaemonSetResource) List(ctx context.Context, namespace string, opts met
     }
     var results []ResourceInfo
     for _, ds := range daemonSets.Items {
-        results = append(results, ResourceInfo{Name: ds.Name, Namespace: ds.Namespace, Type: "daemonset"})
+        if isEdible(&ds) {
+            results = append(results, ResourceInfo{Name: ds.Name, Namespace: ds.Namespace, Type: "daemonset", UID: ds.UID, ResourceVersion: ds.ResourceVersion})
+        }
     }
     return results, nil
 }
@@ -181,7 +229,9 @@ func (s *SecretResource) List(ctx context.Context, namespace string, opts metav1
     }
     var results []ResourceInfo
     for _, secret := range secrets.Items {
-        results = append(results, ResourceInfo{Name: secret.Name, Namespace: secret.Namespace, Type: "secret"})
+        if isEdible(&secret) {
+            results = append(results, ResourceInfo{Name: secret.Name, Namespace: secret.Namespace, Type: "secret", UID: secret.UID, ResourceVersion: secret.ResourceVersion})
+        }
     }
     return results, nil
 }
@@ -193,7 +243,9 @@ func (c *ConfigMapResource) List(ctx context.Context, namespace string, opts met
     }
     var results []ResourceInfo
     for _, cm := range configMaps.Items {
-        results = append(results, ResourceInfo{Name: cm.Name, Namespace: cm.Namespace, Type: "configmap"})
+        if isEdible(&cm) {
+            results = append(results, ResourceInfo{Name: cm.Name, Namespace: cm.Namespace, Type: "configmap", UID: cm.UID, ResourceVersion: cm.ResourceVersion})
+        }
     }
     return results, nil
 }
@@ -205,19 +257,23 @@ func (j *JobResource) List(ctx context.Context, namespace string, opts metav1.Li
     }
     var results []ResourceInfo
     for _, job := range jobs.Items {
-        results = append(results, ResourceInfo{Name: job.Name, Namespace: job.Namespace, Type: "job"})
+        if isEdible(&job) {
+            results = append(results, ResourceInfo{Name: job.Name, Namespace: job.Namespace, Type: "job", UID: job.UID, ResourceVersion: job.ResourceVersion})
+        }
     }
     return results, nil
 }
 
 func (c *CronJobResource) List(ctx context.Context, namespace string, opts metav1.ListOptions) ([]ResourceInfo, error) {
-    cronJobs, err := c.clientset.BatchV1beta1().CronJobs(namespace).List(ctx, opts)
+    cronJobs, err := c.clientset.BatchV1().CronJobs(namespace).List(ctx, opts)
     if err != nil {
         return nil, err
     }
     var results []ResourceInfo
     for _, cj := range cronJobs.Items {
-        results = append(results, ResourceInfo{Name: cj.Name, Namespace: cj.Namespace, Type: "cronjob"})
+        if isEdible(&cj) {
+            results = append(results, ResourceInfo{Name: cj.Name, Namespace: cj.Namespace, Type: "cronjob", UID: cj.UID, ResourceVersion: cj.ResourceVersion})
+        }
     }
     return results, nil
 }
@@ -229,58 +285,105 @@ func (i *IngressResource) List(ctx context.Context, namespace string, opts metav
     }
     var results []ResourceInfo
     for _, ing := range ingresses.Items {
-        results = append(results, ResourceInfo{Name: ing.Name, Namespace: ing.Namespace, Type: "ingress"})
+        if isEdible(&ing) {
+            results = append(results, ResourceInfo{Name: ing.Name, Namespace: ing.Namespace, Type: "ingress", UID: ing.UID, ResourceVersion: ing.ResourceVersion})
+        }
     }
     return results, nil
 }
 
-func (p *PodResource) Delete(ctx context.Context, namespace, name string) error {
-    return p.clientset.CoreV1().Pods(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+// errFoodGoneOff is returned when the served object no longer exists or was
+// replaced by a newer object with the same name.
+var errFoodGoneOff = errors.New("food has gone off")
+
+// errDisruptionBudget is returned when a PodDisruptionBudget refuses an eviction.
+var errDisruptionBudget = errors.New("eviction refused by PodDisruptionBudget")
+
+func (p *PodResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    if gameConfig.PodDeletion == "delete" {
+        return p.clientset.CoreV1().Pods(namespace).Delete(ctx, name, opts)
+    }
+
+    eviction := &policyv1.Eviction{
+        ObjectMeta:    metav1.ObjectMeta{Name: name, Namespace: namespace},
+        DeleteOptions: &opts,
+    }
+    err := p.clientset.CoreV1().Pods(namespace).EvictV1(ctx, eviction)
+    if apierrors.IsTooManyRequests(err) {
+        return fmt.Errorf("%w: %s", errDisruptionBudget, err)
+    }
+    return err
 }
 
-func (r *ReplicaSetResource) Delete(ctx context.Context, namespace, name string) error {
-    return r.clientset.AppsV1().ReplicaSets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (r *ReplicaSetResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return r.clientset.AppsV1().ReplicaSets(namespace).Delete(ctx, name, opts)
 }
 
-func (d *DeploymentResource) Delete(ctx context.Context, namespace, name string) error {
-    return d.clientset.AppsV1().Deployments(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (d *DeploymentResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return d.clientset.AppsV1().Deployments(namespace).Delete(ctx, name, opts)
 }
 
-func (s *StatefulSetResource) Delete(ctx context.Context, namespace, name string) error {
-    return s.clientset.AppsV1().StatefulSets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (s *StatefulSetResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return s.clientset.AppsV1().StatefulSets(namespace).Delete(ctx, name, opts)
 }
 
-func (s *ServiceResource) Delete(ctx context.Context, namespace, name string) error {
-    return s.clientset.CoreV1().Services(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (s *ServiceResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return s.clientset.CoreV1().Services(namespace).Delete(ctx, name, opts)
 }
 
-func (d *DaemonSetResource) Delete(ctx context.Context, namespace, name string) error {
-    return d.clientset.AppsV1().DaemonSets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (d *DaemonSetResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return d.clientset.AppsV1().DaemonSets(namespace).Delete(ctx, name, opts)
 }
 
-func (s *SecretResource) Delete(ctx context.Context, namespace, name string) error {
-    return s.clientset.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (s *SecretResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return s.clientset.CoreV1().Secrets(namespace).Delete(ctx, name, opts)
 }
 
-func (c *ConfigMapResource) Delete(ctx context.Context, namespace, name string) error {
-    return c.clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (c *ConfigMapResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return c.clientset.CoreV1().ConfigMaps(namespace).Delete(ctx, name, opts)
 }
 
-func (j *JobResource) Delete(ctx context.Context, namespace, name string) error {
-    return j.clientset.BatchV1().Jobs(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (j *JobResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return j.clientset.BatchV1().Jobs(namespace).Delete(ctx, name, opts)
 }
 
-func (c *CronJobResource) Delete(ctx context.Context, namespace, name string) error {
-    return c.clientset.BatchV1beta1().CronJobs(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (c *CronJobResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return c.clientset.BatchV1().CronJobs(namespace).Delete(ctx, name, opts)
 }
 
-func (i *IngressResource) Delete(ctx context.Context, namespace, name string) error {
-    return i.clientset.NetworkingV1().Ingresses(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+func (i *IngressResource) Delete(ctx context.Context, namespace, name string, opts metav1.DeleteOptions) error {
+    return i.clientset.NetworkingV1().Ingresses(namespace).Delete(ctx, name, opts)
 }
 
 type Config struct {
+    // Contexts lists the kubeconfig contexts to draw food from, empty uses the current context
+    Contexts      []string `json:"contexts"`
     ResourceTypes []string `json:"resource_types"`
     Namespaces    NamespacesConfig `json:"namespaces"`
+    LabelSelector string `json:"label_selector"`
+    FieldSelector string `json:"field_selector"`
+    // Selectors holds extra selectors per resource type, combined with the global ones
+    Selectors map[string]SelectorConfig `json:"selectors"`
+    // OptIn only serves resources and namespaces marked with serpent.io/edible: "true"
+    OptIn bool `json:"opt_in"`
+    // PodDeletion is either "evict", which respects PodDisruptionBudgets, or "delete"
+    PodDeletion string `json:"pod_deletion"`
+    // PropagationPolicy is Foreground, Background or Orphan, empty leaves it to the server
+    PropagationPolicy string `json:"propagation_policy"`
+    // Owners is "none", "show" to display the owner chain, or "root" to eat the top level owner
+    Owners string `json:"owners"`
+    // Protection guards production clusters against accidental games
+    Protection ProtectionConfig `json:"protection"`
+    // Budgets cap the blast radius of a session
+    Budgets BudgetConfig `json:"budgets"`
+    // HealthChecks are steady state probes, food stops spawning while one fails
+    HealthChecks   []HealthCheckConfig `json:"health_checks"`
+    HealthInterval string              `json:"health_interval"`
+}
+
+type SelectorConfig struct {
+    LabelSelector string `json:"label_selector"`
+    FieldSelector string `json:"field_selector"`
 }
 
 type NamespacesConfig struct {
@@ -294,6 +397,13 @@ var defaultConfig = Config{
         Include: []string{},
         Exclude: []string{"kube-system"},
     },
+    PodDeletion: "evict",
+    Owners:      "none",
+    Protection: ProtectionConfig{
+        Contexts: []string{"*prod*"},
+        Action:   "refuse",
+    },
+    HealthInterval: "10s",
 }
 
 var gameConfig Config
@@ -311,19 +421,82 @@ func loadConfigFromFile(filename string) error {
     if err != nil {
         return err
     }
+    if gameConfig.PodDeletion != "evict" && gameConfig.PodDeletion != "delete" {
+        return fmt.Errorf("invalid pod_deletion %q, expected evict or delete", gameConfig.PodDeletion)
+    }
+    switch metav1.DeletionPropagation(gameConfig.PropagationPolicy) {
+    case "", metav1.DeletePropagationForeground, metav1.DeletePropagationBackground, metav1.DeletePropagationOrphan:
+    default:
+        return fmt.Errorf("invalid propagation_policy %q, expected Foreground, Background or Orphan", gameConfig.PropagationPolicy)
+    }
+    if gameConfig.Owners != "none" && gameConfig.Owners != "show" && gameConfig.Owners != "root" {
+        return fmt.Errorf("invalid owners %q, expected none, show or root", gameConfig.Owners)
+    }
+    if gameConfig.Protection.Action != "refuse" && gameConfig.Protection.Action != "dry-run" {
+        return fmt.Errorf("invalid protection action %q, expected refuse or dry-run", gameConfig.Protection.Action)
+    }
+    for _, pattern := range gameConfig.Protection.Contexts {
+        if _, err := contextPattern(pattern); err != nil {
+            return fmt.Errorf("invalid protected context pattern %q: %w", pattern, err)
+        }
+    }
+    if gameConfig.Budgets.Window.Max > 0 {
+        if period, err := time.ParseDuration(gameConfig.Budgets.Window.Period); err != nil || period <= 0 {
+            return fmt.Errorf("invalid budget window period %q", gameConfig.Budgets.Window.Period)
+        }
+    }
+    for _, check := range gameConfig.HealthChecks {
+        if err := check.validate(); err != nil {
+            return err
+        }
+    }
+    if interval, err := time.ParseDuration(gameConfig.HealthInterval); err != nil || interval <= 0 {
+        return fmt.Errorf("invalid health_interval %q", gameConfig.HealthInterval)
+    }
+    return validateSelectors()
+}
+
+func validateSelectors() error {
+    for _, resourceType := range gameConfig.ResourceTypes {
+        opts := listOptionsFor(resourceType)
+        if _, err := labels.Parse(opts.LabelSelector); err != nil {
+            return fmt.Errorf("invalid label selector for %s: %w", resourceType, err)
+        }
+        if _, err := fields.ParseSelector(opts.FieldSelector); err != nil {
+            return fmt.Errorf("invalid field selector for %s: %w", resourceType, err)
+        }
+    }
     return nil
 }
 
-func getAllNamespaces() ([]string, error) {
-    allNamespaces, err := clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+// listOptionsFor combines the global selectors with those configured for the resource type.
+func listOptionsFor(resourceType string) metav1.ListOptions {
+    perType := gameConfig.Selectors[resourceType]
+    return metav1.ListOptions{
+        LabelSelector: joinSelectors(gameConfig.LabelSelector, perType.LabelSelector),
+        FieldSelector: joinSelectors(gameConfig.FieldSelector, perType.FieldSelector),
+    }
+}
+
+func joinSelectors(selectors ...string) string {
+    var nonEmpty []string
+    for _, selector := range selectors {
+        if selector != "" {
+            nonEmpty = append(nonEmpty, selector)
+        }
+    }
+    return strings.Join(nonEmpty, ",")
+}
+
+func getAllNamespaces(cluster *KubeCluster) ([]string, error) {
+    allNamespaces, err := cluster.clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
     if err != nil {
         return nil, err
     }
 
     var filteredNamespaces []string
     for _, ns := range allNamespaces.Items {
-        if (len(gameConfig.Namespaces.Include) == 0 || contains(gameConfig.Namespaces.Include, ns.Name)) &&
-           !contains(gameConfig.Namespaces.Exclude, ns.Name) {
+        if namespaceAllowed(&ns) {
             filteredNamespaces = append(filteredNamespaces, ns.Name)
         }
     }
@@ -340,33 +513,78 @@ func contains(slice []string, str string) bool {
     return false
 }
 
-func getResourceHandler(resourceType string) (KubernetesResource, error) {
-    switch resourceType {
-    case "pods":
-        return &PodResource{clientset: clientset}, nil
-    case "replicasets":
-        return &ReplicaSetResource{clientset: clientset}, nil
-    case "deployments":
-        return &DeploymentResource{clientset: clientset}, nil
-    case "statefulsets":
-        return &StatefulSetResource{clientset: clientset}, nil
-    case "services":
-        return &ServiceResource{clientset: clientset}, nil
-    case "daemonsets":
-        return &DaemonSetResource{clientset: clientset}, nil
-    case "secrets":
-        return &SecretResource{clientset: clientset}, nil
-    case "configmaps":
-        return &ConfigMapResource{clientset: clientset}, nil
-    case "jobs":
-        return &JobResource{clientset: clientset}, nil
-    case "cronjobs":
-        return &CronJobResource{clientset: clientset}, nil
-    case "ingresses":
-        return &IngressResource{clientset: clientset}, nil
-    default:
+// resourceFactory builds the handler for a single resource kind in a cluster.
+type resourceFactory func(cluster *KubeCluster) KubernetesResource
+
+type resourceEntry struct {
+    singular string
+    gvr      schema.GroupVersionResource
+    factory  resourceFactory
+}
+
+// resourceRegistry maps both the plural name used in the config ("pods") and
+// the singular ResourceInfo.Type ("pod") to the same handler factory, so that
+// anything the snake can be served can also be eaten.
+var (
+    resourceRegistry      = map[string]resourceEntry{}
+    resourceRegistryMutex sync.RWMutex
+)
+
+func registerResource(plural, singular string, gvr schema.GroupVersionResource, factory resourceFactory) {
+    resourceRegistryMutex.Lock()
+    defer resourceRegistryMutex.Unlock()
+    entry := resourceEntry{singular: singular, gvr: gvr, factory: factory}
+    resourceRegistry[plural] = entry
+    resourceRegistry[singular] = entry
+}
+
+func lookupResource(resourceType string) (resourceEntry, bool) {
+    resourceRegistryMutex.RLock()
+    defer resourceRegistryMutex.RUnlock()
+    entry, ok := resourceRegistry[resourceType]
+    return entry, ok
+}
+
+// resourceTypeForGVR returns the ResourceInfo.Type registered for gvr.
+func resourceTypeForGVR(gvr schema.GroupVersionResource) (string, bool) {
+    resourceRegistryMutex.RLock()
+    defer resourceRegistryMutex.RUnlock()
+    for _, entry := range resourceRegistry {
+        if entry.gvr == gvr {
+            return entry.singular, true
+        }
+    }
+    return "", false
+}
+
+func init() {
+    registerResource("pods", "pod", schema.GroupVersionResource{Version: "v1", Resource: "pods"}, func(c *KubeCluster) KubernetesResource { return &PodResource{clientset: c.clientset} })
+    registerResource("replicasets", "replicaset", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "replicasets"}, func(c *KubeCluster) KubernetesResource { return &ReplicaSetResource{clientset: c.clientset} })
+    registerResource("deployments", "deployment", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}, func(c *KubeCluster) KubernetesResource { return &DeploymentResource{clientset: c.clientset} })
+    registerResource("statefulsets", "statefulset", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}, func(c *KubeCluster) KubernetesResource { return &StatefulSetResource{clientset: c.clientset} })
+    registerResource("services", "service", schema.GroupVersionResource{Version: "v1", Resource: "services"}, func(c *KubeCluster) KubernetesResource { return &ServiceResource{clientset: c.clientset} })
+    registerResource("daemonsets", "daemonset", schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "daemonsets"}, func(c *KubeCluster) KubernetesResource { return &DaemonSetResource{clientset: c.clientset} })
+    registerResource("secrets", "secret", schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, func(c *KubeCluster) KubernetesResource { return &SecretResource{clientset: c.clientset} })
+    registerResource("configmaps", "configmap", schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}, func(c *KubeCluster) KubernetesResource { return &ConfigMapResource{clientset: c.clientset} })
+    registerResource("jobs", "job", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "jobs"}, func(c *KubeCluster) KubernetesResource { return &JobResource{clientset: c.clientset} })
+    registerResource("cronjobs", "cronjob", schema.GroupVersionResource{Group: "batch", Version: "v1", Resource: "cronjobs"}, func(c *KubeCluster) KubernetesResource { return &CronJobResource{clientset: c.clientset} })
+    registerResource("ingresses", "ingress", schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}, func(c *KubeCluster) KubernetesResource { return &IngressResource{clientset: c.clientset} })
+}
+
+func getResourceHandler(cluster *KubeCluster, resourceType string) (KubernetesResource, error) {
+    entry, ok := lookupResource(resourceType)
+    if !ok {
         return nil, fmt.Errorf("unsupported resource type: %s", resourceType)
     }
+    return entry.factory(cluster), nil
+}
+
+func getResourceGVR(resourceType string) (schema.GroupVersionResource, error) {
+    entry, ok := lookupResource(resourceType)
+    if !ok {
+        return schema.GroupVersionResource{}, fmt.Errorf("unsupported resource type: %s", resourceType)
+    }
+    return entry.gvr, nil
 }
 
 type PodInfo struct {
@@ -374,93 +592,208 @@ type PodInfo struct {
 	Namespace string
 }
 
-var clientset *kubernetes.Clientset
-
-func initKubeClient() {
-	var kubeconfig string
-	if kc := os.Getenv("KUBECONFIG"); kc != "" {
-		kubeconfig = kc
-	} else {
-		home := homedir.HomeDir()
-		kubeconfig = filepath.Join(home, ".kube", "config")
-	}
-
-	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
-	if err != nil {
-		log.Printf("Error building kubeconfig: %s\n", err.Error())
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			log.Fatalf("Error building kube config: %s\n", err.Error())
-		}
-	}
-
-	clientset, err = kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatalf("Error creating clientset: %s\n", err.Error())
-	}
-}
+// maxBudgetAttempts bounds how many candidates are drawn looking for one within budget
+const maxBudgetAttempts = 10
 
 func getRandomResourceInfo() (ResourceInfo, error) {
-    if len(gameConfig.ResourceTypes) == 0 {
-        return ResourceInfo{}, fmt.Errorf("no resource types configured")
+    resourceInfo, ok := candidates.Random(targetRand)
+    if !ok {
+        log.Println("No eligible resources found.")
+        return ResourceInfo{}, fmt.Errorf("no eligible resources found")
     }
-
-    var allResources []ResourceInfo
-    for _, resourceType := range gameConfig.ResourceTypes {
-        handler, err := getResourceHandler(resourceType)
-        if err != nil {
-            log.Printf("Error getting handler for resource type %s: %s\n", resourceType, err)
-            continue
+    // Food stops spawning for categories whose budget is used up
+    for attempt := 1; !budget.Allows(resourceInfo); attempt++ {
+        if attempt == maxBudgetAttempts {
+            return ResourceInfo{}, fmt.Errorf("no resources found within budget")
         }
-
-        filteredNamespaces, err := getAllNamespaces()
-        if err != nil {
-            log.Printf("Error fetching namespaces: %s\n", err)
-            continue
+        // The pool may have emptied since the last pick
+        if resourceInfo, ok = candidates.Random(targetRand); !ok {
+            log.Println("No eligible resources found.")
+            return ResourceInfo{}, fmt.Errorf("no eligible resources found")
         }
+    }
+    resourceInfo.candidate = candidateKey(resourceInfo)
+    resourceInfo.candidateUID = resourceInfo.UID
 
-        for _, ns := range filteredNamespaces {
-            resources, err := handler.List(context.TODO(), ns, metav1.ListOptions{})
-            if err != nil {
-                log.Printf("Error listing resources in namespace %s: %s\n", ns, err)
-                continue
-            }
-            allResources = append(allResources, resources...)
+    if gameConfig.Owners != "none" {
+        if err := resolveOwners(&resourceInfo); err != nil {
+            log.Printf("Error resolving owners of %s %s in namespace %s on %s: %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster, err)
         }
     }
+    return resourceInfo, nil
+}
 
-    if len(allResources) == 0 {
-        log.Println("No eligible resources found.")
-        return ResourceInfo{}, fmt.Errorf("no eligible resources found")
+
+const (
+    immuneKey = "serpent.io/immune"
+    edibleKey = "serpent.io/edible"
+)
+
+// isEdible reports whether an object may become food. Objects labelled or
+// annotated as immune never are, and in opt-in mode they must be marked edible.
+func isEdible(obj metav1.Object) bool {
+    if hasMarker(obj, immuneKey) {
+        return false
+    }
+    if gameConfig.OptIn {
+        return hasMarker(obj, edibleKey)
     }
+    return true
+}
 
-    randIndex := rand.Intn(len(allResources))
-    return allResources[randIndex], nil
+// isEdibleIn is isEdible for an object in namespace ns. In opt-in mode
+// marking either the object or its namespace edible is enough.
+func isEdibleIn(obj metav1.Object, ns metav1.Object) bool {
+    if hasMarker(obj, immuneKey) || hasMarker(ns, immuneKey) {
+        return false
+    }
+    if gameConfig.OptIn {
+        return hasMarker(obj, edibleKey) || hasMarker(ns, edibleKey)
+    }
+    return true
 }
 
+func hasMarker(obj metav1.Object, key string) bool {
+    return obj.GetLabels()[key] == "true" || obj.GetAnnotations()[key] == "true"
+}
 
-func isCriticalPod(pod v1.Pod) bool {
-	_, isCritical := pod.Annotations["scheduler.alpha.kubernetes.io/critical-pod"]
+func isCriticalPod(pod metav1.Object) bool {
+	_, isCritical := pod.GetAnnotations()["scheduler.alpha.kubernetes.io/critical-pod"]
 	return isCritical
 }
 
-func deleteResource(resourceInfo ResourceInfo) {
-    var err error
-    switch resourceInfo.Type {
-    case "pod":
-        err = clientset.CoreV1().Pods(resourceInfo.Namespace).Delete(context.TODO(), resourceInfo.Name, metav1.DeleteOptions{})
-    case "replicaset":
-        err = clientset.AppsV1().ReplicaSets(resourceInfo.Namespace).Delete(context.TODO(), resourceInfo.Name, metav1.DeleteOptions{})
-	case "deployment":
-        err = clientset.AppsV1().Deployments(resourceInfo.Namespace).Delete(context.TODO(), resourceInfo.Name, metav1.DeleteOptions{})
+// DryRunMode controls whether eating food actually removes anything from the cluster.
+type DryRunMode string
+
+const (
+    DryRunNone   DryRunMode = ""
+    DryRunServer DryRunMode = "server"
+    DryRunClient DryRunMode = "client"
+)
+
+var dryRun = DryRunNone
+
+func (d *DryRunMode) String() string {
+    return string(*d)
+}
+
+func (d *DryRunMode) Set(value string) error {
+    switch value {
+    case "true", "server":
+        *d = DryRunServer
+    case "client":
+        *d = DryRunClient
+    case "false", "none":
+        *d = DryRunNone
     default:
+        return fmt.Errorf("invalid dry run mode %q, expected server or client", value)
+    }
+    return nil
+}
+
+// IsBoolFlag lets a bare --dry-run select server side dry run.
+func (d *DryRunMode) IsBoolFlag() bool {
+    return true
+}
+
+// checkNoArgs refuses stray arguments. Since --dry-run is a bool flag,
+// "--dry-run client" would otherwise run a server side dry run.
+func checkNoArgs(fs *flag.FlagSet) {
+    if fs.NArg() > 0 {
+        log.Fatalf("Unexpected arguments %q, set the dry run mode with --dry-run=client or --dry-run=server", fs.Args())
+    }
+}
+
+// deleteOptions returns the options every delete call sends to the API server.
+func deleteOptions() metav1.DeleteOptions {
+    opts := metav1.DeleteOptions{}
+    if gameConfig.PropagationPolicy != "" {
+        policy := metav1.DeletionPropagation(gameConfig.PropagationPolicy)
+        opts.PropagationPolicy = &policy
+    }
+    if dryRun == DryRunServer {
+        opts.DryRun = []string{metav1.DryRunAll}
+    }
+    return opts
+}
+
+func deleteResource(resourceInfo ResourceInfo) error {
+    // Food served before a health check failed is not eaten either
+    if !clusterHealthy() {
+        err := fmt.Errorf("%w: %s", errClusterHurting, strings.Join(failingProbes(), ", "))
+        log.Printf("Not deleting %s %s in namespace %s on %s: %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster, err)
+        auditDeletion(resourceInfo, err)
+        observeDeletion(resourceInfo, err)
+        return err
+    }
+
+    // Budgets are enforced before anything is deleted
+    spent, err := budget.Spend(resourceInfo)
+    if err != nil {
+        log.Printf("Not deleting %s %s in namespace %s on %s: %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster, err)
+        auditDeletion(resourceInfo, err)
+        observeDeletion(resourceInfo, err)
+        return err
+    }
+    err = removeResource(resourceInfo)
+    if err != nil {
+        budget.Refund(resourceInfo, spent)
+    }
+    auditDeletion(resourceInfo, err)
+    observeDeletion(resourceInfo, err)
+    return err
+}
+
+func removeResource(resourceInfo ResourceInfo) error {
+    // Client side dry run never talks to the API server
+    if dryRun == DryRunClient {
+        log.Printf("%s deleted (client dry run): %s in namespace %s on %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster)
+        return nil
+    }
+
+    cluster, err := getCluster(resourceInfo.Cluster)
+    if err != nil {
+        log.Printf("Error connecting to %s: %s\n", resourceInfo.Cluster, err)
+        return err
+    }
+
+    handler, err := getResourceHandler(cluster, resourceInfo.Type)
+    if err != nil {
         log.Printf("Unsupported resource type: %s", resourceInfo.Type)
-        return
+        return err
     }
 
+    // Keep a copy of the manifest so it can be regurgitated later
+    var snapshotPath string
+    if dryRun == DryRunNone {
+        snapshotPath, err = snapshotResource(cluster, resourceInfo)
+        if err != nil {
+            log.Printf("Error snapshotting %s %s in namespace %s on %s, not deleting it: %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster, err.Error())
+            return err
+        }
+    }
+
+    opts := deleteOptions()
+    if resourceInfo.UID != "" {
+        opts.Preconditions = metav1.NewUIDPreconditions(string(resourceInfo.UID))
+    }
+    err = handler.Delete(context.TODO(), resourceInfo.Namespace, resourceInfo.Name, opts)
+    if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+        err = fmt.Errorf("%w: %s", errFoodGoneOff, err)
+    }
     if err != nil {
-        log.Printf("Error deleting %s %s in namespace %s: %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, err.Error())
+        log.Printf("Error deleting %s %s in namespace %s on %s: %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster, err.Error())
+        // Nothing was eaten, so there is nothing to regurgitate
+        if snapshotPath != "" {
+            if err := os.Remove(snapshotPath); err != nil {
+                log.Printf("Error removing snapshot %s: %s\n", snapshotPath, err)
+            }
+        }
+    } else if dryRun == DryRunServer {
+        log.Printf("%s deleted (server dry run): %s in namespace %s on %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster)
     } else {
-        log.Printf("%s deleted: %s in namespace %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace)
+        log.Printf("%s deleted: %s in namespace %s on %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster)
+        recordEatenEvent(cluster, resourceInfo)
     }
+    return err
 }
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// newFakeCluster returns a cluster backed by a fake clientset holding objects.
+// Evictions delete the pod, like the API server does when no budget objects.
+func newFakeCluster(objects ...runtime.Object) *KubeCluster {
+    clientset := fake.NewSimpleClientset(objects...)
+    clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+        create := action.(k8stesting.CreateAction)
+        if create.GetSubresource() != "eviction" {
+            return false, nil, nil
+        }
+        name := create.GetObject().(metav1.Object).GetName()
+        return true, nil, clientset.Tracker().Delete(action.GetResource(), action.GetNamespace(), name)
+    })
+    return &KubeCluster{Context: "fake", clientset: clientset}
+}
+
+func TestRegisteredResourcesListAndDelete(t *testing.T) {
+    setDefaultConfig()
+
+    meta := metav1.ObjectMeta{Name: "food", Namespace: "default"}
+    fixtures := map[string]runtime.Object{
+        "pod":         &v1.Pod{ObjectMeta: meta},
+        "replicaset":  &appsv1.ReplicaSet{ObjectMeta: meta},
+        "deployment":  &appsv1.Deployment{ObjectMeta: meta},
+        "statefulset": &appsv1.StatefulSet{ObjectMeta: meta},
+        "service":     &v1.Service{ObjectMeta: meta},
+        "daemonset":   &appsv1.DaemonSet{ObjectMeta: meta},
+        "secret":      &v1.Secret{ObjectMeta: meta},
+        "configmap":   &v1.ConfigMap{ObjectMeta: meta},
+        "job":         &batchv1.Job{ObjectMeta: meta},
+        "cronjob":     &batchv1.CronJob{ObjectMeta: meta},
+        "ingress":     &networkingv1.Ingress{ObjectMeta: meta},
+    }
+
+    // Every entry is registered under its plural and singular name
+    entries := map[string]resourceEntry{}
+    resourceRegistryMutex.RLock()
+    for _, entry := range resourceRegistry {
+        entries[entry.singular] = entry
+    }
+    resourceRegistryMutex.RUnlock()
+
+    for singular, entry := range entries {
+        t.Run(singular, func(t *testing.T) {
+            fixture, ok := fixtures[singular]
+            if !ok {
+                t.Fatalf("no fixture for registered resource type %s", singular)
+            }
+            handler := entry.factory(newFakeCluster(fixture.DeepCopyObject()))
+            ctx := context.Background()
+
+            listed, err := handler.List(ctx, "default", metav1.ListOptions{})
+            if err != nil {
+                t.Fatalf("List: %s", err)
+            }
+            if len(listed) != 1 || listed[0].Name != "food" || listed[0].Type != singular {
+                t.Fatalf("List returned %+v, want one %s named food", listed, singular)
+            }
+
+            if err := handler.Delete(ctx, "default", "food", deleteOptions()); err != nil {
+                t.Fatalf("Delete: %s", err)
+            }
+
+            listed, err = handler.List(ctx, "default", metav1.ListOptions{})
+            if err != nil {
+                t.Fatalf("List after Delete: %s", err)
+            }
+            if len(listed) != 0 {
+                t.Fatalf("List after Delete returned %+v, want nothing", listed)
+            }
+        })
+    }
+}
+
+func TestPodDeleteWithoutEviction(t *testing.T) {
+    setDefaultConfig()
+    gameConfig.PodDeletion = "delete"
+    defer setDefaultConfig()
+
+    handler := &PodResource{clientset: newFakeCluster(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "food", Namespace: "default"}}).clientset}
+    if err := handler.Delete(context.Background(), "default", "food", deleteOptions()); err != nil {
+        t.Fatalf("Delete: %s", err)
+    }
+    listed, err := handler.List(context.Background(), "default", metav1.ListOptions{})
+    if err != nil {
+        t.Fatalf("List: %s", err)
+    }
+    if len(listed) != 0 {
+        t.Fatalf("List after Delete returned %+v, want nothing", listed)
+    }
+}
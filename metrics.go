@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var metricsAddr string
+
+// addMetricsFlags registers the flag that turns on the metrics endpoint.
+func addMetricsFlags(fs *flag.FlagSet) {
+    fs.StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address, e.g. :9090")
+}
+
+var (
+    deletionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+        Name: "serpent_deletions_total",
+        Help: "Attempts to eat a resource, by resource type, namespace and outcome.",
+    }, []string{"type", "namespace", "outcome"})
+
+    apiRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+        Name:    "serpent_api_request_duration_seconds",
+        Help:    "Latency of list and delete requests to the Kubernetes API.",
+        Buckets: prometheus.DefBuckets,
+    }, []string{"cluster", "operation"})
+
+    _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "serpent_candidate_pool_size",
+        Help: "Resources that can currently be served as food.",
+    }, func() float64 { return float64(candidates.Len()) })
+
+    _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "serpent_food_queue_depth",
+        Help: "Resources queued up to be served as food.",
+    }, func() float64 { return float64(len(resourceInfoQueue)) })
+
+    _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+        Name: "serpent_score",
+        Help: "Current score.",
+    }, func() float64 { return float64(score.Load()) })
+)
+
+// observeDeletion counts an attempt to eat a resource.
+func observeDeletion(resourceInfo ResourceInfo, err error) {
+    deletionsTotal.WithLabelValues(resourceInfo.Type, resourceInfo.Namespace, deletionOutcome(err)).Inc()
+}
+
+// startMetricsServer serves /metrics in the background when --metrics-addr is set.
+func startMetricsServer() {
+    if metricsAddr == "" {
+        return
+    }
+    mux := http.NewServeMux()
+    mux.Handle("/metrics", promhttp.Handler())
+    go func() {
+        if err := http.ListenAndServe(metricsAddr, mux); err != nil {
+            log.Printf("Metrics server stopped: %s\n", err)
+        }
+    }()
+}
+
+// instrumentedTransport times the list and delete requests of one cluster.
+type instrumentedTransport struct {
+    cluster string
+    next    http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+    operation := apiOperation(request)
+    if operation == "" {
+        return t.next.RoundTrip(request)
+    }
+    start := time.Now()
+    response, err := t.next.RoundTrip(request)
+    apiRequestDuration.WithLabelValues(t.cluster, operation).Observe(time.Since(start).Seconds())
+    return response, err
+}
+
+// apiOperation classifies a request as "list" or "delete", or "" for anything else.
+// Evictions count as deletes.
+func apiOperation(request *http.Request) string {
+    path := strings.Trim(request.URL.Path, "/")
+    switch {
+    case request.Method == http.MethodDelete:
+        return "delete"
+    case request.Method == http.MethodPost && strings.HasSuffix(path, "/eviction"):
+        return "delete"
+    case request.Method != http.MethodGet || request.URL.Query().Get("watch") == "true":
+        return ""
+    }
+
+    // Below /api/v1 or /apis/group/version, collections are an odd number of
+    // segments (pods, namespaces/x/pods) and single objects an even number
+    segments := strings.Split(path, "/")
+    switch {
+    case len(segments) > 2 && segments[0] == "api":
+        segments = segments[2:]
+    case len(segments) > 3 && segments[0] == "apis":
+        segments = segments[3:]
+    default:
+        return ""
+    }
+    if len(segments)%2 == 1 {
+        return "list"
+    }
+    return ""
+}
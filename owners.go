@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// maxOwnerDepth guards against ownerReference cycles
+const maxOwnerDepth = 10
+
+type OwnerInfo struct {
+    Type            string
+    Name            string
+    UID             types.UID
+    ResourceVersion string
+}
+
+func (o OwnerInfo) String() string {
+    return o.Type + " " + o.Name
+}
+
+// describeOwners formats the owner chain as "replicaset x -> deployment y".
+func describeOwners(owners []OwnerInfo) string {
+    var parts []string
+    for _, owner := range owners {
+        parts = append(parts, owner.String())
+    }
+    return strings.Join(parts, " -> ")
+}
+
+// resolveOwners walks the controller ownerReferences of the resource and records
+// the chain. In root mode the resource is replaced by its top level owner.
+func resolveOwners(resourceInfo *ResourceInfo) error {
+    err := walkOwners(resourceInfo)
+    if gameConfig.Owners == "root" && len(resourceInfo.Owners) > 0 {
+        root := resourceInfo.Owners[len(resourceInfo.Owners)-1]
+        resourceInfo.EatenVia = fmt.Sprintf("%s %s", resourceInfo.Type, resourceInfo.Name)
+        resourceInfo.Type = root.Type
+        resourceInfo.Name = root.Name
+        resourceInfo.UID = root.UID
+        resourceInfo.ResourceVersion = root.ResourceVersion
+        resourceInfo.Owners = nil
+    }
+    return err
+}
+
+func walkOwners(resourceInfo *ResourceInfo) error {
+    cluster, err := getCluster(resourceInfo.Cluster)
+    if err != nil {
+        return err
+    }
+    gvr, err := getResourceGVR(resourceInfo.Type)
+    if err != nil {
+        return err
+    }
+    obj, err := cluster.dynamicClient.Resource(gvr).Namespace(resourceInfo.Namespace).Get(context.TODO(), resourceInfo.Name, metav1.GetOptions{})
+    if err != nil {
+        return err
+    }
+
+    for depth := 0; depth < maxOwnerDepth; depth++ {
+        ref := metav1.GetControllerOf(obj)
+        if ref == nil {
+            return nil
+        }
+
+        gv, err := schema.ParseGroupVersion(ref.APIVersion)
+        if err != nil {
+            return err
+        }
+        mapping, err := cluster.restMapper.RESTMapping(schema.GroupKind{Group: gv.Group, Kind: ref.Kind}, gv.Version)
+        if err != nil {
+            return err
+        }
+        // Cluster scoped owners are out of the snake's reach
+        if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+            return nil
+        }
+
+        owner, err := cluster.dynamicClient.Resource(mapping.Resource).Namespace(resourceInfo.Namespace).Get(context.TODO(), ref.Name, metav1.GetOptions{})
+        if err != nil {
+            return err
+        }
+        // Never climb past an immune owner when it would be eaten
+        if gameConfig.Owners == "root" && !ownerEdible(cluster, owner) {
+            return nil
+        }
+
+        resourceInfo.Owners = append(resourceInfo.Owners, OwnerInfo{Type: ownerResourceType(cluster, mapping.Resource, ref.Kind), Name: ref.Name, UID: owner.GetUID(), ResourceVersion: owner.GetResourceVersion()})
+        obj = owner
+    }
+    return nil
+}
+
+// ownerResourceType returns the ResourceInfo.Type for an owner, registering
+// a dynamic handler for kinds the snake has not seen before.
+func ownerResourceType(cluster *KubeCluster, gvr schema.GroupVersionResource, kind string) string {
+    if resourceType, ok := resourceTypeForGVR(gvr); ok {
+        return resourceType
+    }
+    singular, err := cluster.restMapper.ResourceSingularizer(gvr.Resource)
+    if err != nil {
+        singular = ""
+    }
+    return registerDynamicResource(formatGroupVersionResource(gvr), gvr, singular, kind)
+}
+
+// ownerEdible reports whether an owner may be eaten, taking an opted-in namespace into account.
+func ownerEdible(cluster *KubeCluster, owner metav1.Object) bool {
+    if cluster.namespaceLister == nil {
+        return isEdible(owner)
+    }
+    ns, err := cluster.namespaceLister.Get(owner.GetNamespace())
+    if err != nil {
+        return isEdible(owner)
+    }
+    return isEdibleIn(owner, ns)
+}
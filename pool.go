@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// CandidatePool holds every resource that can currently be served as food.
+// It is kept up to date from informer watch events.
+type CandidatePool struct {
+    mutex sync.Mutex
+    items []ResourceInfo
+    index map[string]int
+    // keys holds every key in sorted order, so seeded picks do not depend
+    // on the order the informers filled the pool in
+    keys []string
+}
+
+func NewCandidatePool() *CandidatePool {
+    return &CandidatePool{index: map[string]int{}}
+}
+
+func candidateKey(resourceInfo ResourceInfo) string {
+    return resourceInfo.Cluster + "/" + resourceInfo.Type + "/" + resourceInfo.Namespace + "/" + resourceInfo.Name
+}
+
+func (p *CandidatePool) Add(resourceInfo ResourceInfo) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    key := candidateKey(resourceInfo)
+    if i, ok := p.index[key]; ok {
+        p.items[i] = resourceInfo
+        return
+    }
+    p.index[key] = len(p.items)
+    p.items = append(p.items, resourceInfo)
+
+    i := sort.SearchStrings(p.keys, key)
+    p.keys = append(p.keys, "")
+    copy(p.keys[i+1:], p.keys[i:])
+    p.keys[i] = key
+}
+
+func (p *CandidatePool) Remove(key string) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    i, ok := p.index[key]
+    if !ok {
+        return
+    }
+    // Swap with the last item so removal stays O(1)
+    last := p.items[len(p.items)-1]
+    p.items[i] = last
+    p.index[candidateKey(last)] = i
+    p.items = p.items[:len(p.items)-1]
+    delete(p.index, key)
+
+    j := sort.SearchStrings(p.keys, key)
+    p.keys = append(p.keys[:j], p.keys[j+1:]...)
+}
+
+func (p *CandidatePool) Get(key string) (ResourceInfo, bool) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    i, ok := p.index[key]
+    if !ok {
+        return ResourceInfo{}, false
+    }
+    return p.items[i], true
+}
+
+// Random picks a candidate with rng. The same seed picks the same candidate
+// from the same pool, whatever order the informers filled it in.
+func (p *CandidatePool) Random(rng *rand.Rand) (ResourceInfo, bool) {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    if len(p.keys) == 0 {
+        return ResourceInfo{}, false
+    }
+    return p.items[p.index[p.keys[rng.Intn(len(p.keys))]]], true
+}
+
+func (p *CandidatePool) Len() int {
+    p.mutex.Lock()
+    defer p.mutex.Unlock()
+    return len(p.items)
+}
+
+var candidates = NewCandidatePool()
+
+// resourceInformer tracks one resource type in one namespace scope of a cluster.
+type resourceInformer struct {
+    cluster      *KubeCluster
+    resourceType string
+    informer     cache.SharedIndexInformer
+}
+
+var (
+    resourceInformers      []resourceInformer
+    resourceInformersMutex sync.Mutex
+)
+
+// startCandidatePool starts informers for every configured resource type and
+// namespace in every cluster, feeding the candidate pool until stopCh is closed.
+func startCandidatePool(stopCh <-chan struct{}) error {
+    for _, cluster := range clusters {
+        if err := watchCluster(cluster, stopCh); err != nil {
+            return fmt.Errorf("%s: %w", cluster.Context, err)
+        }
+    }
+    return nil
+}
+
+func watchCluster(cluster *KubeCluster, stopCh <-chan struct{}) error {
+    namespaceFactory := informers.NewSharedInformerFactory(cluster.clientset, 0)
+    namespaceInformer := namespaceFactory.Core().V1().Namespaces()
+    cluster.namespaceLister = namespaceInformer.Lister()
+    namespaceInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+        AddFunc:    func(obj interface{}) { resyncNamespace(cluster, obj) },
+        UpdateFunc: func(_, obj interface{}) { resyncNamespace(cluster, obj) },
+    })
+    namespaceFactory.Start(stopCh)
+    namespaceFactory.WaitForCacheSync(stopCh)
+
+    // Watch only the included namespaces, or everything when none are listed
+    scopes := gameConfig.Namespaces.Include
+    if len(scopes) == 0 {
+        scopes = []string{metav1.NamespaceAll}
+    }
+
+    for _, resourceType := range gameConfig.ResourceTypes {
+        entry, ok := lookupResource(resourceType)
+        if !ok {
+            return fmt.Errorf("unsupported resource type: %s", resourceType)
+        }
+        listOptions := listOptionsFor(resourceType)
+        tweak := func(opts *metav1.ListOptions) {
+            opts.LabelSelector = listOptions.LabelSelector
+            opts.FieldSelector = listOptions.FieldSelector
+        }
+
+        for _, namespace := range scopes {
+            // Built in types use the typed factory, anything else the dynamic one
+            var informer cache.SharedIndexInformer
+            var start func(<-chan struct{})
+            factory := informers.NewSharedInformerFactoryWithOptions(cluster.clientset, 0, informers.WithNamespace(namespace), informers.WithTweakListOptions(tweak))
+            if generic, err := factory.ForResource(entry.gvr); err == nil {
+                informer = generic.Informer()
+                start = factory.Start
+            } else {
+                dynamicFactory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(cluster.dynamicClient, 0, namespace, tweak)
+                informer = dynamicFactory.ForResource(entry.gvr).Informer()
+                start = dynamicFactory.Start
+            }
+
+            singular := entry.singular
+            informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+                AddFunc:    func(obj interface{}) { updateCandidate(cluster, singular, obj) },
+                UpdateFunc: func(_, obj interface{}) { updateCandidate(cluster, singular, obj) },
+                DeleteFunc: func(obj interface{}) { removeCandidate(cluster, singular, obj) },
+            })
+
+            resourceInformersMutex.Lock()
+            resourceInformers = append(resourceInformers, resourceInformer{cluster: cluster, resourceType: singular, informer: informer})
+            resourceInformersMutex.Unlock()
+
+            start(stopCh)
+        }
+    }
+    return nil
+}
+
+// namespaceAllowed applies the include and exclude lists and the immunity marker to a namespace.
+// In opt-in mode a namespace without the edible marker may still hold edible resources.
+func namespaceAllowed(ns *v1.Namespace) bool {
+    return (len(gameConfig.Namespaces.Include) == 0 || contains(gameConfig.Namespaces.Include, ns.Name)) &&
+        !contains(gameConfig.Namespaces.Exclude, ns.Name) && !hasMarker(ns, immuneKey)
+}
+
+func isEligible(cluster *KubeCluster, resourceType string, obj metav1.Object) bool {
+    ns, err := cluster.namespaceLister.Get(obj.GetNamespace())
+    if err != nil || !namespaceAllowed(ns) {
+        return false
+    }
+    if resourceType == "pod" && isCriticalPod(obj) {
+        return false
+    }
+    return isEdibleIn(obj, ns)
+}
+
+func updateCandidate(cluster *KubeCluster, resourceType string, obj interface{}) {
+    accessor, err := meta.Accessor(obj)
+    if err != nil {
+        log.Printf("Error reading %s from informer: %s\n", resourceType, err)
+        return
+    }
+    resourceInfo := ResourceInfo{Name: accessor.GetName(), Namespace: accessor.GetNamespace(), Type: resourceType, Cluster: cluster.Context, UID: accessor.GetUID(), ResourceVersion: accessor.GetResourceVersion()}
+    if isEligible(cluster, resourceType, accessor) {
+        candidates.Add(resourceInfo)
+    } else {
+        candidates.Remove(candidateKey(resourceInfo))
+    }
+}
+
+func removeCandidate(cluster *KubeCluster, resourceType string, obj interface{}) {
+    if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+        obj = tombstone.Obj
+    }
+    accessor, err := meta.Accessor(obj)
+    if err != nil {
+        log.Printf("Error reading deleted %s from informer: %s\n", resourceType, err)
+        return
+    }
+    candidates.Remove(candidateKey(ResourceInfo{Name: accessor.GetName(), Namespace: accessor.GetNamespace(), Type: resourceType, Cluster: cluster.Context}))
+}
+
+// resyncNamespace re-evaluates every cached resource in a namespace after its
+// labels or annotations change.
+func resyncNamespace(cluster *KubeCluster, obj interface{}) {
+    ns, ok := obj.(*v1.Namespace)
+    if !ok {
+        return
+    }
+    resourceInformersMutex.Lock()
+    defer resourceInformersMutex.Unlock()
+    for _, ri := range resourceInformers {
+        if ri.cluster != cluster {
+            continue
+        }
+        items, err := ri.informer.GetIndexer().ByIndex(cache.NamespaceIndex, ns.Name)
+        if err != nil {
+            continue
+        }
+        for _, item := range items {
+            updateCandidate(cluster, ri.resourceType, item)
+        }
+    }
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// preflightCheck is a single access review the game depends on.
+type preflightCheck struct {
+    verb        string
+    subresource string
+    // name limits the check to a single object
+    name string
+}
+
+func (c preflightCheck) String() string {
+    if c.subresource != "" {
+        return c.verb + " " + c.subresource
+    }
+    return c.verb
+}
+
+// preflightChecks lists the access the game needs for a resource type, in column order:
+// list and watch to serve food, delete (or evict) to eat it, get and create to restore it.
+func preflightChecks(resourceType string) []preflightCheck {
+    checks := []preflightCheck{{verb: "list"}, {verb: "watch"}}
+    entry, _ := lookupResource(resourceType)
+    if entry.singular == "pod" && gameConfig.PodDeletion == "evict" {
+        checks = append(checks, preflightCheck{verb: "create", subresource: "eviction"})
+    } else {
+        checks = append(checks, preflightCheck{verb: "delete"})
+    }
+    return append(checks, preflightCheck{verb: "get"}, preflightCheck{verb: "create"})
+}
+
+// canI asks the API server whether the current identity may perform the check.
+func canI(cluster *KubeCluster, gvr schema.GroupVersionResource, namespace string, check preflightCheck) (bool, error) {
+    review := &authorizationv1.SelfSubjectAccessReview{
+        Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+            ResourceAttributes: &authorizationv1.ResourceAttributes{
+                Namespace:   namespace,
+                Verb:        check.verb,
+                Group:       gvr.Group,
+                Version:     gvr.Version,
+                Resource:    gvr.Resource,
+                Subresource: check.subresource,
+                Name:        check.name,
+            },
+        },
+    }
+    result, err := cluster.clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), review, metav1.CreateOptions{})
+    if err != nil {
+        return false, err
+    }
+    return result.Status.Allowed, nil
+}
+
+var (
+    namespacesGVR  = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+    eventsGVR      = schema.GroupVersionResource{Version: "v1", Resource: "events"}
+    podsGVR        = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+    deploymentsGVR = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+// skipCheck marks a column a row does not need.
+var skipCheck = preflightCheck{}
+
+// preflightNamespaces returns the namespaces the game would play in on a cluster.
+func preflightNamespaces(cluster *KubeCluster) []string {
+    if len(gameConfig.Namespaces.Include) > 0 {
+        return gameConfig.Namespaces.Include
+    }
+    namespaces, err := getAllNamespaces(cluster)
+    if err != nil {
+        log.Printf("Cannot list namespaces on %s, checking cluster wide access instead: %s\n", cluster.Context, err)
+        return []string{metav1.NamespaceAll}
+    }
+    return namespaces
+}
+
+// runPreflight implements the "serpent preflight" subcommand.
+func runPreflight(args []string) {
+    preflightFlags := flag.NewFlagSet("preflight", flag.ExitOnError)
+    addConfigFlags(preflightFlags)
+    addKubeFlags(preflightFlags)
+    preflightFlags.Parse(args)
+
+    loadConfig()
+    connectClusters()
+
+    denied := 0
+    writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+    fmt.Fprintln(writer, "CONTEXT\tNAMESPACE\tRESOURCE\tLIST\tWATCH\tDELETE\tGET\tCREATE\t")
+
+    // row reviews checks, one per column, and prints the result
+    row := func(cluster *KubeCluster, namespace, name string, gvr schema.GroupVersionResource, checks []preflightCheck) {
+        marks := ""
+        for _, check := range checks {
+            mark := "yes"
+            if check == skipCheck {
+                marks += "-\t"
+                continue
+            }
+            allowed, err := canI(cluster, gvr, namespace, check)
+            if err != nil {
+                mark = "error"
+                log.Printf("Error reviewing %s %s in namespace %s on %s: %s\n", check, name, namespace, cluster.Context, err)
+            } else if !allowed {
+                mark = "no"
+            }
+            if mark != "yes" {
+                denied++
+            }
+            marks += mark + "\t"
+        }
+        displayNamespace := namespace
+        if namespace == metav1.NamespaceAll {
+            displayNamespace = "*"
+        }
+        fmt.Fprintf(writer, "%s\t%s\t%s\t%s\n", cluster.Context, displayNamespace, name, marks)
+    }
+
+    for _, cluster := range clusters {
+        // The namespace informer watches every namespace
+        row(cluster, metav1.NamespaceAll, "namespaces", namespacesGVR, []preflightCheck{{verb: "list"}, {verb: "watch"}, skipCheck, skipCheck, skipCheck})
+
+        // Without an include list every type is watched across all namespaces
+        if len(gameConfig.Namespaces.Include) == 0 {
+            for _, resourceType := range gameConfig.ResourceTypes {
+                gvr, err := getResourceGVR(resourceType)
+                if err != nil {
+                    log.Fatalf("Failed to check %s: %s", resourceType, err)
+                }
+                row(cluster, metav1.NamespaceAll, resourceType, gvr, []preflightCheck{{verb: "list"}, {verb: "watch"}, skipCheck, skipCheck, skipCheck})
+            }
+        }
+
+        for _, namespace := range preflightNamespaces(cluster) {
+            for _, resourceType := range gameConfig.ResourceTypes {
+                gvr, err := getResourceGVR(resourceType)
+                if err != nil {
+                    log.Fatalf("Failed to check %s: %s", resourceType, err)
+                }
+                row(cluster, namespace, resourceType, gvr, preflightChecks(resourceType))
+            }
+            // Every deletion is announced with an event
+            row(cluster, namespace, "events", eventsGVR, []preflightCheck{skipCheck, skipCheck, skipCheck, skipCheck, {verb: "create"}})
+        }
+
+        // Kubernetes health checks read their own namespaces
+        for _, check := range gameConfig.HealthChecks {
+            if check.HTTP != nil {
+                continue
+            }
+            checkCluster, err := check.cluster()
+            if err != nil {
+                log.Fatal(err)
+            }
+            if checkCluster != cluster {
+                continue
+            }
+            if check.Deployment != nil {
+                row(cluster, check.Deployment.Namespace, fmt.Sprintf("deployments (%s)", check.Name), deploymentsGVR, []preflightCheck{skipCheck, skipCheck, skipCheck, {verb: "get", name: check.Deployment.Name}, skipCheck})
+            } else {
+                row(cluster, check.PodReadiness.Namespace, fmt.Sprintf("pods (%s)", check.Name), podsGVR, []preflightCheck{{verb: "list"}, skipCheck, skipCheck, skipCheck, skipCheck})
+            }
+        }
+    }
+    writer.Flush()
+
+    if denied > 0 {
+        fmt.Printf("\n%d permission(s) missing, the game will log errors for those in chaos.log.\n", denied)
+        os.Exit(1)
+    }
+    fmt.Println("\nAll good, go eat something.")
+}
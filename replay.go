@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	tl "github.com/JoelOtter/termloop"
+	"github.com/deggja/serpent/engine"
+)
+
+// replayFileName is the recording written to every session directory.
+const replayFileName = "replay.jsonl.gz"
+
+// ReplayHeader is the first line of a replay file.
+type ReplayHeader struct {
+    Version int          `json:"version"`
+    Session string       `json:"session"`
+    Seed    int64        `json:"seed"`
+    State   engine.State `json:"state"`
+}
+
+// ReplayRecord is one line of a replay file. Steps with no input are not
+// recorded, so Tick counts every engine step since the start.
+type ReplayRecord struct {
+    Tick  int           `json:"tick"`
+    Input *engine.Input `json:"input,omitempty"`
+    // FoodColor is the colour of the food placed by Input
+    FoodColor tl.Attr `json:"food_color,omitempty"`
+    // Message is text shown to the player
+    Message string `json:"message,omitempty"`
+    // Outcome is the result of eating Resource, as in the audit log
+    Outcome  string `json:"outcome,omitempty"`
+    Resource string `json:"resource,omitempty"`
+    // Restart is the state a new round started from
+    Restart *engine.State `json:"restart,omitempty"`
+}
+
+// replayRecorder writes a gzipped JSON Lines recording of the game.
+type replayRecorder struct {
+    mutex   sync.Mutex
+    file    *os.File
+    gz      *gzip.Writer
+    encoder *json.Encoder
+    tick    int
+}
+
+// recorder is nil when the game is not being recorded
+var recorder *replayRecorder
+
+// startRecording records the game from state into the session directory.
+func startRecording(state engine.State) error {
+    file, err := os.Create(filepath.Join(sessionDir, replayFileName))
+    if err != nil {
+        return err
+    }
+    gz := gzip.NewWriter(file)
+    recorder = &replayRecorder{file: file, gz: gz, encoder: json.NewEncoder(gz)}
+    return recorder.encoder.Encode(ReplayHeader{Version: 1, Session: sessionID(), Seed: seed, State: state})
+}
+
+// stopRecording flushes the recording to disk.
+func stopRecording() {
+    if recorder == nil {
+        return
+    }
+    recorder.mutex.Lock()
+    defer recorder.mutex.Unlock()
+    recorder.gz.Close()
+    recorder.file.Close()
+}
+
+func (r *replayRecorder) write(record ReplayRecord) {
+    if r == nil {
+        return
+    }
+    r.mutex.Lock()
+    defer r.mutex.Unlock()
+    record.Tick = r.tick
+    if err := r.encoder.Encode(record); err != nil {
+        log.Printf("Error recording replay: %s\n", err)
+    }
+}
+
+// recordStep records the input of an engine step and moves on to the next tick.
+func (r *replayRecorder) recordStep(input engine.Input) {
+    if r == nil {
+        return
+    }
+    if input != (engine.Input{}) {
+        record := ReplayRecord{Input: &input}
+        if input.PlaceFood != nil {
+            record.FoodColor = foodColor(input.PlaceFood.ID)
+        }
+        r.write(record)
+    }
+    r.mutex.Lock()
+    r.tick++
+    r.mutex.Unlock()
+}
+
+func (r *replayRecorder) recordMessage(message string) {
+    r.write(ReplayRecord{Message: message})
+}
+
+func (r *replayRecorder) recordRestart(state engine.State) {
+    r.write(ReplayRecord{Restart: &state})
+}
+
+func (r *replayRecorder) recordOutcome(resourceInfo ResourceInfo, err error) {
+    r.write(ReplayRecord{Outcome: deletionOutcome(err), Resource: fmt.Sprintf("%s %s in namespace %s", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace)})
+}
+
+// readReplay loads a replay file.
+func readReplay(path string) (ReplayHeader, []ReplayRecord, error) {
+    var header ReplayHeader
+    file, err := os.Open(path)
+    if err != nil {
+        return header, nil, err
+    }
+    defer file.Close()
+    gz, err := gzip.NewReader(file)
+    if err != nil {
+        return header, nil, err
+    }
+
+    decoder := json.NewDecoder(bufio.NewReader(gz))
+    if err := decoder.Decode(&header); err != nil {
+        return header, nil, fmt.Errorf("reading header: %w", err)
+    }
+    var records []ReplayRecord
+    for {
+        var record ReplayRecord
+        err := decoder.Decode(&record)
+        // A game killed mid-write leaves a truncated file, play what is there
+        if err == io.EOF || err == io.ErrUnexpectedEOF {
+            break
+        }
+        if err != nil {
+            return header, records, err
+        }
+        records = append(records, record)
+    }
+    return header, records, nil
+}
+
+// ReplayPlayer steps the engine through a recording and renders it.
+type ReplayPlayer struct {
+    records  []ReplayRecord
+    next     int
+    tick     int
+    speed    float64
+    progress float64
+    paused   bool
+    finished bool
+}
+
+func (p *ReplayPlayer) Tick(event tl.Event) {
+    if event.Type == tl.EventKey {
+        switch {
+        case event.Key == tl.KeySpace:
+            p.paused = !p.paused
+        case event.Ch == '+':
+            p.speed *= 2
+        case event.Ch == '-':
+            p.speed /= 2
+        }
+    }
+    if p.paused || p.finished {
+        return
+    }
+
+    // Recordings are made at the game's frame rate, speed steps faster or slower
+    for p.progress += p.speed; p.progress >= 1 && !p.finished; p.progress-- {
+        p.step()
+    }
+}
+
+// step applies the records of the current tick and advances the engine once.
+func (p *ReplayPlayer) step() {
+    input := engine.Input{}
+    resized := false
+    for ; p.next < len(p.records) && p.records[p.next].Tick == p.tick; p.next++ {
+        record := p.records[p.next]
+        switch {
+        case record.Restart != nil:
+            gameState = *record.Restart
+            finalScoreText.SetPosition(-1, -1)
+            deletedPodText.SetText("")
+        case record.Input != nil:
+            input = *record.Input
+            resized = input.Resize != nil
+            if input.PlaceFood != nil {
+                foodCellColors[input.PlaceFood.ID] = record.FoodColor
+            }
+        case record.Message != "":
+            deletedPodText.SetText(record.Message)
+        case record.Outcome != "":
+            clusterText.SetText(fmt.Sprintf("%s: %s", record.Resource, record.Outcome))
+        }
+    }
+
+    var events []engine.Event
+    gameState, events = engine.Step(gameState, input)
+    score.Store(int64(gameState.Score))
+    scoreText.SetText(fmt.Sprintf("Score: %d", gameState.Score))
+    p.tick++
+    if resized {
+        layoutHUD()
+    }
+
+    for _, event := range events {
+        if event.Type == engine.GameOver {
+            showFinalScreen()
+        }
+    }
+    if p.next >= len(p.records) {
+        p.finished = true
+        pauseText.SetText("End of replay. Press CTRL+C to quit.")
+        updatePauseTextPosition()
+    }
+}
+
+func (p *ReplayPlayer) Draw(screen *tl.Screen) {
+    drawSnake(screen)
+    drawFood(screen)
+}
+
+// runReplay implements the "serpent replay" subcommand. It never talks to a cluster.
+func runReplay(args []string) {
+    replayFlags := flag.NewFlagSet("replay", flag.ExitOnError)
+    speed := replayFlags.Float64("speed", 1, "Playback speed, + and - double or halve it while playing")
+    replayFlags.Usage = func() {
+        fmt.Fprintf(replayFlags.Output(), "Usage: serpent replay [--speed N] <file>\n")
+        replayFlags.PrintDefaults()
+    }
+    replayFlags.Parse(args)
+    if replayFlags.NArg() != 1 || *speed <= 0 {
+        replayFlags.Usage()
+        os.Exit(2)
+    }
+
+    header, records, err := readReplay(replayFlags.Arg(0))
+    if err != nil {
+        log.Fatalf("Failed to read replay: %s", err)
+    }
+    gameState = header.State
+
+    game = tl.NewGame()
+    game.Screen().SetFps(30)
+    level := tl.NewBaseLevel(tl.Cell{
+        Bg: tl.ColorBlack,
+        Fg: tl.ColorWhite,
+        Ch: ' ',
+    })
+
+    level.AddEntity(&ReplayPlayer{records: records, speed: *speed})
+
+    scoreText = tl.NewText(1, 0, "Score: 0", tl.ColorWhite, tl.ColorBlack)
+    deletedPodText = tl.NewText(1, gameState.Height, "", tl.ColorWhite, tl.ColorBlack)
+    clusterText = tl.NewText(1, gameState.Height+1, fmt.Sprintf("Replaying session %s (seed %d). Space pauses, + and - change speed.", header.Session, header.Seed), tl.ColorRed, tl.ColorBlack)
+    level.AddEntity(scoreText)
+    level.AddEntity(deletedPodText)
+    level.AddEntity(clusterText)
+
+    newOverlays(level, "")
+
+    game.Screen().SetLevel(level)
+    game.Start()
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+	"time"
+)
+
+// runHeadless implements the "serpent run" subcommand, which eats a resource
+// on a schedule without a terminal, e.g. from a Deployment inside the cluster.
+func runHeadless(args []string) {
+    runFlags := flag.NewFlagSet("run", flag.ExitOnError)
+    runFlags.Var(&dryRun, "dry-run", "Simulate deletions: --dry-run or --dry-run=server sends dry run deletes, --dry-run=client skips the API entirely")
+    sessionBaseDir := runFlags.String("session-dir", "sessions", "Directory where snapshots of eaten resources are stored")
+    interval := runFlags.Duration("interval", time.Minute, "Time between deletions")
+    count := runFlags.Int("count", 0, "Stop after this many deletions (0 runs forever)")
+    addSeedFlag(runFlags)
+    addConfigFlags(runFlags)
+    addKubeFlags(runFlags)
+    addMetricsFlags(runFlags)
+    addLogFlags(runFlags, "")
+    runFlags.Parse(args)
+    checkNoArgs(runFlags)
+
+    logFile, err := setupLogging()
+    if err != nil {
+        log.Fatal(err)
+    }
+    if logFile != nil {
+        defer logFile.Close()
+    }
+    startMetricsServer()
+
+    loadConfig()
+    connectClusters()
+
+    // Nobody is around to type the cluster name of a protected context
+    for _, cluster := range protectedClusters() {
+        if dryRun != DryRunNone {
+            break
+        }
+        if gameConfig.Protection.Action != "dry-run" {
+            log.Fatalf("Refusing to run headless against protected %s", cluster)
+        }
+        log.Printf("Context %s is protected, running in dry run mode\n", cluster.Context)
+        dryRun = DryRunServer
+    }
+
+    if err := startSession(*sessionBaseDir); err != nil {
+        log.Fatalf("Failed to create session directory: %s", err)
+    }
+    seedSession()
+    if err := startCandidatePool(make(chan struct{})); err != nil {
+        log.Fatalf("Failed to watch resources: %s", err)
+    }
+    go fetchResources()
+    startHealthChecks()
+
+    for _, cluster := range clusters {
+        log.Printf("Running against %s, eating every %s\n", cluster, *interval)
+    }
+
+    ticker := time.NewTicker(*interval)
+    defer ticker.Stop()
+    for eaten := 0; *count == 0 || eaten < *count; {
+        <-ticker.C
+        if !clusterHealthy() {
+            log.Printf("Health checks failing (%s), skipping this round.\n", strings.Join(failingProbes(), ", "))
+            continue
+        }
+        resourceInfo, ok := nextResourceInfo()
+        if !ok {
+            log.Println("Nothing to eat this round.")
+            continue
+        }
+        log.Printf("Eating %s %s in namespace %s on %s\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, resourceInfo.Cluster)
+        // The score is the number of resources eaten so far, as in the game
+        resourceInfo.Score = int(score.Add(1))
+        if err := deleteResource(resourceInfo); err == nil {
+            eaten++
+        } else {
+            score.Add(-1)
+        }
+    }
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	tl "github.com/JoelOtter/termloop"
+)
+
+type ProtectionConfig struct {
+    // Contexts are glob patterns, e.g. "*prod*", matched against the kubeconfig context name
+    Contexts []string `json:"contexts"`
+    // AllowedServers lists the only API server URLs that may be played against without confirmation
+    AllowedServers []string `json:"allowed_servers"`
+    // Action is "refuse" or "dry-run", what happens unless the cluster name is typed in
+    Action string `json:"action"`
+}
+
+// isProtectedContext reports whether a cluster is a protected context or server.
+func isProtectedContext(cluster *KubeCluster) bool {
+    for _, pattern := range gameConfig.Protection.Contexts {
+        matcher, err := contextPattern(pattern)
+        if err == nil && matcher.MatchString(cluster.Context) {
+            return true
+        }
+    }
+    return len(gameConfig.Protection.AllowedServers) > 0 && !contains(gameConfig.Protection.AllowedServers, cluster.Server)
+}
+
+// contextPattern compiles a protected context glob. Unlike path.Match, * also
+// matches slashes, so ARN style context names like EKS ones are matched too.
+func contextPattern(pattern string) (*regexp.Regexp, error) {
+    var expr strings.Builder
+    expr.WriteString("^")
+    for _, r := range pattern {
+        switch r {
+        case '*':
+            expr.WriteString(".*")
+        case '?':
+            expr.WriteString(".")
+        default:
+            expr.WriteString(regexp.QuoteMeta(string(r)))
+        }
+    }
+    expr.WriteString("$")
+    return regexp.Compile(expr.String())
+}
+
+func protectedClusters() []*KubeCluster {
+    var protected []*KubeCluster
+    for _, cluster := range clusters {
+        if isProtectedContext(cluster) {
+            protected = append(protected, cluster)
+        }
+    }
+    return protected
+}
+
+// ConfirmPrompt asks the player to type the cluster name before a protected
+// cluster can be played against.
+type ConfirmPrompt struct {
+    *tl.Text
+    cluster   *KubeCluster
+    input     string
+    errorText *tl.Text
+    onDone    func()
+}
+
+// newConfirmLevel builds the confirmation screen for a cluster, calling onDone once it may be played against.
+func newConfirmLevel(cluster *KubeCluster, onDone func()) *tl.BaseLevel {
+    level := tl.NewBaseLevel(tl.Cell{Bg: tl.ColorBlack, Fg: tl.ColorWhite, Ch: ' '})
+
+    lines := []string{
+        fmt.Sprintf("Context %s is protected.", cluster.Context),
+        fmt.Sprintf("Type the cluster name (%s) and press Enter to start deleting.", cluster.Name),
+    }
+    if gameConfig.Protection.Action == "dry-run" {
+        lines = append(lines, "Press Enter without typing to play the whole game in dry run mode.")
+    }
+    lines = append(lines, "Press CTRL+C to QUIT.")
+    for i, line := range lines {
+        level.AddEntity(tl.NewText(2, 2+i, line, tl.ColorWhite, tl.ColorBlack))
+    }
+
+    prompt := &ConfirmPrompt{
+        Text:      tl.NewText(2, 3+len(lines), "> ", tl.ColorGreen, tl.ColorBlack),
+        cluster:   cluster,
+        errorText: tl.NewText(2, 5+len(lines), "", tl.ColorRed, tl.ColorBlack),
+        onDone:    onDone,
+    }
+    level.AddEntity(prompt)
+    level.AddEntity(prompt.errorText)
+    return level
+}
+
+func (p *ConfirmPrompt) Tick(event tl.Event) {
+    if event.Type != tl.EventKey {
+        return
+    }
+    switch {
+    case event.Key == tl.KeyEnter:
+        p.submit()
+    case event.Key == tl.KeyBackspace || event.Key == tl.KeyBackspace2:
+        if len(p.input) > 0 {
+            p.input = p.input[:len(p.input)-1]
+        }
+    case event.Key == tl.KeySpace:
+        p.input += " "
+    case event.Ch != 0:
+        p.input += string(event.Ch)
+    }
+    p.SetText("> " + p.input)
+}
+
+func (p *ConfirmPrompt) submit() {
+    switch {
+    case p.input == p.cluster.Name:
+        p.onDone()
+    case strings.TrimSpace(p.input) == "" && gameConfig.Protection.Action == "dry-run":
+        if dryRun == DryRunNone {
+            dryRun = DryRunServer
+        }
+        p.onDone()
+    default:
+        p.errorText.SetText("That is not the name of the cluster.")
+        p.input = ""
+    }
+}
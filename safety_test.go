@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIsProtectedContext(t *testing.T) {
+    setDefaultConfig()
+    defer setDefaultConfig()
+    gameConfig.Protection.Contexts = []string{"*prod*", "kind-?"}
+
+    tests := []struct {
+        context string
+        want    bool
+    }{
+        {context: "prod", want: true},
+        {context: "eu-prod-1", want: true},
+        {context: "arn:aws:eks:eu-west-1:123456789012:cluster/prod-checkout", want: true},
+        {context: "gke_project_europe-west1_prod", want: true},
+        {context: "kind-a", want: true},
+        {context: "kind-ab", want: false},
+        {context: "arn:aws:eks:eu-west-1:123456789012:cluster/staging", want: false},
+        {context: "minikube", want: false},
+    }
+
+    for _, test := range tests {
+        t.Run(test.context, func(t *testing.T) {
+            if got := isProtectedContext(&KubeCluster{Context: test.context}); got != test.want {
+                t.Errorf("isProtectedContext(%q) = %t, want %t", test.context, got, test.want)
+            }
+        })
+    }
+}
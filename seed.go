@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"math/rand"
+	"time"
+)
+
+var seed int64
+
+// addSeedFlag registers the flag that makes a session reproducible.
+func addSeedFlag(fs *flag.FlagSet) {
+    fs.Int64Var(&seed, "seed", 0, "Seed for food placement and target selection, 0 picks one at random")
+}
+
+var (
+    // foodRand places food on the board
+    foodRand *rand.Rand
+    // targetRand picks the resources served as food
+    targetRand *rand.Rand
+)
+
+// seedSession sets up the random sources of this session and logs the seed,
+// so the session can be played again with --seed.
+func seedSession() {
+    if seed == 0 {
+        seed = time.Now().UnixNano()
+    }
+    foodRand = rand.New(rand.NewSource(seed))
+    targetRand = rand.New(rand.NewSource(seed + 1))
+    log.Printf("Session %s uses seed %d\n", sessionID(), seed)
+}
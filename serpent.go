@@ -1,315 +1,547 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	tl "github.com/JoelOtter/termloop"
+	"github.com/deggja/serpent/engine"
 )
 
-type Coordinates struct {
-	X, Y int
-}
+// Snake renders the game state and drives the engine from termloop events.
+type Snake struct{}
 
-type Snake struct {
-	body      []Coordinates
-	direction string
-	tickCount int
-	growth    int
-}
+// Food renders the food on the board and spawns new food once it is eaten.
+type Food struct{}
 
-type Food struct {
-	*tl.Entity
-	placed bool
-}
+// gameState is the engine state of the running game
+var gameState engine.State
+
+// pendingFood is put on the board by the next step
+var pendingFood *engine.Food
+
+var nextFoodID int
 
 var foodPodMappings []FoodPodMapping
 
 type FoodPodMapping struct {
-    foodEntity   *Food
+    foodID       int
     resourceInfo ResourceInfo
 }
 
-const (
-	LevelWidth  = 80
-	LevelHeight = 24
-)
-
-func NewFood() *Food {
-	return &Food{
-		Entity: tl.NewEntityFromCanvas(2, 2, tl.CanvasFromString("O")),
-		placed: false,
+func (f *Food) Tick(event tl.Event) {
+	// Spawn new food once the last one has been eaten
+	if gameState.Food != nil || pendingFood != nil || gameState.Over {
+		return
 	}
+	// No new food while a health check fails
+	if !clusterHealthy() {
+		return
+	}
+	f.PlaceFood()
 }
 
-func (f *Food) Tick(event tl.Event) {
-	// Check if food has been placed, if not, place the food
-	if !f.placed {
-		width, height := game.Screen().Size()
-		if width > 0 && height > 0 {
-			f.PlaceFood(width, height)
-			f.placed = true
-		}
+func (f *Food) PlaceFood() {
+	position := randomFoodPosition()
+
+	// Get a random resource name and namespace to associate with this food
+	if resourceInfo, ok := nextResourceInfo(); ok {
+		spawnFood(position, &resourceInfo)
+	} else {
+		log.Println("No resource info available at the moment.")
+		spawnFood(position, nil)
 	}
 }
 
-func (f *Food) PlaceFood(levelWidth, levelHeight int) {
-	rand.Seed(time.Now().UnixNano())
-	foodX := rand.Intn(LevelWidth-4) + 2
-	foodY := rand.Intn(LevelHeight-4) + 2
-
-	f.SetPosition(foodX, foodY)
+// randomFoodPosition picks a spot for food inside the walls.
+func randomFoodPosition() engine.Point {
+	return engine.Point{X: foodRand.Intn(gameState.Width-4) + 2, Y: foodRand.Intn(gameState.Height-4) + 2}
+}
 
-	// Get a random resource name and namespace to associate with this food
-	select {
-    case resourceInfo := <-resourceInfoQueue:
+// spawnFood queues food at position for the next step, linked to resourceInfo when given.
+func spawnFood(position engine.Point, resourceInfo *ResourceInfo) {
+    nextFoodID++
+    pendingFood = &engine.Food{ID: nextFoodID, Position: position}
+    if resourceInfo != nil {
         foodPodMappings = append(foodPodMappings, FoodPodMapping{
-            foodEntity: f,
-            resourceInfo:    resourceInfo,
+            foodID:       nextFoodID,
+            resourceInfo: *resourceInfo,
         })
-    default:
-        log.Println("No resource info available at the moment.")
+        // Food is coloured after the cluster its resource lives in
+        if cluster, err := getCluster(resourceInfo.Cluster); err == nil {
+            foodCellColors[nextFoodID] = cluster.Color
+        }
     }
 }
 
-func (f *Food) Draw(screen *tl.Screen) {
-	// Draw food after it has been placed
-	if f.placed {
-		f.Entity.Draw(screen)
+// foodCellColors holds the colour of every food by ID
+var foodCellColors = map[int]tl.Attr{}
+
+func foodColor(foodID int) tl.Attr {
+	if color, ok := foodCellColors[foodID]; ok {
+		return color
 	}
+	return tl.ColorDefault
 }
 
-func (f *Food) AtPosition(x, y int) bool {
-	foodX, foodY := f.Position()
-	// Check for collision in a wider range for X to accommodate faster horizontal movement
-	return (x == foodX || x == foodX-1 || x == foodX+1) && y == foodY
+func (f *Food) Draw(screen *tl.Screen) {
+	drawFood(screen)
+}
+
+func drawFood(screen *tl.Screen) {
+	// Draw food after it has been placed
+	if gameState.Food != nil {
+		screen.RenderCell(gameState.Food.Position.X, gameState.Food.Position.Y, &tl.Cell{Fg: foodColor(gameState.Food.ID), Ch: 'O'})
+	}
 }
 
 func drawWalls(screen *tl.Screen) {
 	// Top and bottom walls
-	for x := 0; x < LevelWidth; x++ {
+	for x := 0; x < gameState.Width; x++ {
 		screen.RenderCell(x, 0, &tl.Cell{Fg: tl.ColorWhite, Ch: '-'})             // Top wall
-		screen.RenderCell(x, LevelHeight-1, &tl.Cell{Fg: tl.ColorWhite, Ch: '-'}) // Bottom wall
+		screen.RenderCell(x, gameState.Height-1, &tl.Cell{Fg: tl.ColorWhite, Ch: '-'}) // Bottom wall
 	}
 	// Left and right walls
-	for y := 0; y < LevelHeight; y++ {
+	for y := 0; y < gameState.Height; y++ {
 		screen.RenderCell(0, y, &tl.Cell{Fg: tl.ColorWhite, Ch: '|'})            // Left wall
-		screen.RenderCell(LevelWidth-1, y, &tl.Cell{Fg: tl.ColorWhite, Ch: '|'}) // Right wall
-	}
-}
-
-func (snake *Snake) CollidesWithWalls() bool {
-	head := snake.body[0]
-	return head.X < 1 || head.Y < 1 || head.X >= LevelWidth-1 || head.Y >= LevelHeight-1
-}
-
-func (snake *Snake) CollidesWithSelf() bool {
-	head := snake.body[0]
-	for _, segment := range snake.body[1:] {
-		if head.X == segment.X && head.Y == segment.Y {
-			return true
-		}
+		screen.RenderCell(gameState.Width-1, y, &tl.Cell{Fg: tl.ColorWhite, Ch: '|'}) // Right wall
 	}
-	return false
 }
 
 func GameOver() {
+	phase = phaseOver
 	showFinalScreen()
 	log.Println("Game Over!")
 }
 
-func NewSnake(x, y int) *Snake {
-	snake := &Snake{
-		direction: "right",
-		tickCount: 0,
-		growth:    0,
-	}
-	// Initialize snake with 3 segments
-	for i := 0; i < 3; i++ {
-		snake.body = append(snake.body, Coordinates{X: x - i*2, Y: y})
-	}
-	return snake
+func (snake *Snake) Draw(screen *tl.Screen) {
+	drawSnake(screen)
 }
 
-func (snake *Snake) Draw(screen *tl.Screen) {
+func drawSnake(screen *tl.Screen) {
 	drawWalls(screen)
-	for _, segment := range snake.body {
+	for _, segment := range gameState.Snake {
 		screen.RenderCell(segment.X, segment.Y, &tl.Cell{Fg: tl.ColorGreen, Ch: '■'})
 	}
 }
 
-func showFinalScreen() {
-    // Set up a blank level to display end game information
-    blankLevel := tl.NewBaseLevel(tl.Cell{
-        Bg: tl.ColorBlack,  // Background color of the level
-        Ch: ' ',            // Character to fill the screen with
-    })
-    game.Screen().SetLevel(blankLevel)
+// gamePhase is where the player is in a round.
+type gamePhase int
 
-    // Create the final score message
-    finalMessage := fmt.Sprintf("Final Score: %d", score)
-    messageLength := len(finalMessage)
-    startX := (LevelWidth / 2) - (messageLength / 2)
-    startY := LevelHeight / 2 - 1  // Positioned slightly above center for multiple lines
+const (
+    phaseTitle gamePhase = iota
+    phasePlaying
+    phasePaused
+    phaseOver
+)
 
-    finalScoreText := tl.NewText(startX, startY, finalMessage, tl.ColorWhite, tl.ColorBlack)
-    blankLevel.AddEntity(finalScoreText)
+var phase = phaseTitle
+
+// newOverlays adds the texts shown over the board to level, all hidden.
+func newOverlays(level *tl.BaseLevel, restartMessage string) {
+    titleText = tl.NewText(-1, -1, "SERPENT. Press ENTER to START or CTRL+C to QUIT.", tl.ColorGreen, tl.ColorBlack)
+    pauseText = tl.NewText(-1, -1, "GAME PAUSED. Press space to RESUME or CTRL+C to QUIT.", tl.ColorWhite, tl.ColorBlack)
+    finalScoreText = tl.NewText(-1, -1, "", tl.ColorWhite, tl.ColorBlack)
+    restartText = tl.NewText(-1, -1, restartMessage, tl.ColorWhite, tl.ColorBlack)
+    for _, text := range []*tl.Text{titleText, pauseText, finalScoreText, restartText} {
+        level.AddEntity(text)
+    }
+}
+
+// centerText puts text in the middle of the board, dy lines below the center.
+func centerText(text *tl.Text, dy int) {
+    text.SetPosition((gameState.Width/2)-(len(text.Text())/2), (gameState.Height/2)+dy)
+}
+
+func showTitleScreen() {
+    phase = phaseTitle
+    centerText(titleText, 0)
+}
+
+func showFinalScreen() {
+    // Show the final score over the board
+    finalScoreText.SetText(fmt.Sprintf("Final Score: %d", score.Load()))
+    centerText(finalScoreText, -1) // Positioned slightly above center for multiple lines
 
     // Instructions for restarting or quitting
-    restartMessage := "Press CTRL+C to QUIT"
-    restartX := (LevelWidth / 2) - (len(restartMessage) / 2)
-    restartY := startY + 2
+    centerText(restartText, 1)
+}
+
+// restartGame starts a new round on the same board. The clusters, informers,
+// budgets and session stay as they are.
+func restartGame() {
+    gameState = engine.NewState(gameState.Width, gameState.Height, 20, min(20, gameState.Height/2))
+    pendingFood = nil
+    foodPodMappings = nil
+    foodCellColors = map[int]tl.Attr{}
+
+    // Bounces from eats still in flight carry the old round and cost nothing
+    round++
+    for len(hudMessages) > 0 {
+        <-hudMessages
+    }
 
-    restartText := tl.NewText(restartX, restartY, restartMessage, tl.ColorWhite, tl.ColorBlack)
-    blankLevel.AddEntity(restartText)
+    score.Store(0)
+    scoreText.SetText("Score: 0")
+    deletedPodText.SetText("")
+    finalScoreText.SetPosition(-1, -1)
+    restartText.SetPosition(-1, -1)
 
-    game.Screen().Draw()
+    recorder.recordRestart(gameState)
+    log.Println("Restarting the game")
+    phase = phasePlaying
 }
 
 func updatePauseTextPosition() {
-    message := "Game paused. Press space to resume or CTRL+C to quit."
-    messageLength := len(message)
-    
-    // Center horizontally
-    startX := (LevelWidth / 2) - (messageLength / 2)
-    // Center vertically
-    startY := LevelHeight / 2
-    
-    pauseText.SetPosition(startX, startY)
+    centerText(pauseText, 0)
+}
+
+// updateHurtingText shows the failing health checks in the middle of the board.
+func updateHurtingText() {
+    if clusterHealthy() {
+        hurtingText.SetPosition(-1, -1)
+        return
+    }
+    message := fmt.Sprintf("The cluster is hurting (%s). No food until it recovers.", strings.Join(failingProbes(), ", "))
+    hurtingText.SetText(message)
+    hurtingText.SetPosition((gameState.Width/2)-(len(message)/2), (gameState.Height/2)-2)
+}
+
+// score mirrors gameState.Score for the headless runner and metrics, which read it from other goroutines
+var score atomic.Int64
+
+// round counts the rounds played, so bounces from an earlier round are not held against this one
+var round int
+
+// bounce is food refused by a PodDisruptionBudget in the round it was eaten in
+type bounce struct {
+    resourceInfo ResourceInfo
+    round        int
+}
+
+// bouncedFood receives resources whose eviction was blocked by a PodDisruptionBudget
+var bouncedFood = make(chan bounce, 10)
+
+// eating tracks deletions started by eatFood, so the game can wait for them on exit
+var eating sync.WaitGroup
+
+// hudMessages carries messages from background goroutines to the HUD
+var hudMessages = make(chan string, 10)
+
+func regurgitate() {
+    restored, err := restoreSnapshots(sessionDir, 1)
+    switch {
+    case err != nil:
+        log.Printf("Error regurgitating: %s\n", err)
+        hudMessages <- fmt.Sprintf("Blergh! Could not regurgitate: %s", err)
+    case len(restored) == 0:
+        hudMessages <- "Nothing left to regurgitate."
+    default:
+        hudMessages <- fmt.Sprintf("Blergh! You regurgitated %s", restored[0])
+    }
+}
+
+func eatResource(resourceInfo ResourceInfo, eatenInRound int) {
+    err := deleteResource(resourceInfo)
+    recorder.recordOutcome(resourceInfo, err)
+    // The HUD may be gone by now, only the deletion is waited for
+    eating.Done()
+    switch {
+    case errors.Is(err, errDisruptionBudget):
+        bouncedFood <- bounce{resourceInfo: resourceInfo, round: eatenInRound}
+    case errors.Is(err, errBudgetExhausted):
+        hudMessages <- fmt.Sprintf("Spat out %s: %s in namespace %s, %s", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, err)
+    case errors.Is(err, errClusterHurting):
+        hudMessages <- fmt.Sprintf("Spat out %s: %s in namespace %s, the %s", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, err)
+    case errors.Is(err, errFoodGoneOff):
+        hudMessages <- fmt.Sprintf("Yuck! That food had gone off, %s: %s in namespace %s was already gone", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace)
+    }
+}
+
+// showMessage tells the player what happened below the board.
+func showMessage(message string) {
+    deletedPodText.SetText(message)
+    recorder.recordMessage(message)
 }
 
-var score int
+// arrowDirections maps arrow keys to the direction the snake turns to
+var arrowDirections = map[tl.Key]engine.Direction{
+    tl.KeyArrowRight: engine.Right,
+    tl.KeyArrowLeft:  engine.Left,
+    tl.KeyArrowUp:    engine.Up,
+    tl.KeyArrowDown:  engine.Down,
+}
 
 func (snake *Snake) Tick(event tl.Event) {
-    // Check for pause toggle first
-    if event.Type == tl.EventKey && event.Key == tl.KeySpace {
-        isPaused = !isPaused
-        if isPaused {
-            updatePauseTextPosition()
-        } else {
-            // Move text off-screen when unpaused
-            pauseText.SetPosition(-1, -1)
+    // Follow the terminal size, also while paused
+    if event.Type == tl.EventResize {
+        if width, height := boardSize(game.Screen().Size()); width != gameState.Width || height != gameState.Height {
+            snake.step(engine.Input{Resize: &engine.Size{Width: width, Height: height}})
+            layoutHUD()
         }
-        return // Return early to avoid processing other inputs or game logic
+        return
     }
 
-    // If the game is paused, skip updating the game logic
-    if isPaused {
+    switch phase {
+    case phaseTitle:
+        if event.Type == tl.EventKey && event.Key == tl.KeyEnter {
+            titleText.SetPosition(-1, -1)
+            phase = phasePlaying
+        }
+        return
+    case phaseOver:
+        if event.Type == tl.EventKey && (event.Ch == 'r' || event.Ch == 'R') {
+            restartGame()
+        }
         return
     }
 
-    // Handle direction change input
-    if event.Type == tl.EventKey {
-        switch event.Key {
-        case tl.KeyArrowRight:
-            if snake.direction != "left" {
-                snake.direction = "right"
-            }
-        case tl.KeyArrowLeft:
-            if snake.direction != "right" {
-                snake.direction = "left"
-            }
-        case tl.KeyArrowUp:
-            if snake.direction != "down" {
-                snake.direction = "up"
-            }
-        case tl.KeyArrowDown:
-            if snake.direction != "up" {
-                snake.direction = "down"
-            }
-        }
+    // Check for pause toggle first, nothing else happens while paused
+    if event.Type == tl.EventKey && event.Key == tl.KeySpace {
+        snake.step(engine.Input{TogglePause: true})
+        return
+    }
+    if phase == phasePaused {
+        return
     }
 
-    // Update snake every two ticks
-    snake.tickCount++
-    if snake.tickCount >= 2 {
-        snake.tickCount = 0
-        newHead := snake.body[0]
-        // Move head based on the current direction
-        switch snake.direction {
-        case "right":
-            newHead.X += 2
-        case "left":
-            newHead.X -= 2
-        case "up":
-            newHead.Y -= 1
-        case "down":
-            newHead.Y += 1
-        }
+    budgetText.SetText(budget.Summary())
+    updateHurtingText()
 
-        // Check for food collision
-        if food.AtPosition(newHead.X, newHead.Y) {
-            snake.growth += 1
-            food.placed = false
-            score++
-            scoreText.SetText(fmt.Sprintf("Score: %d", score))
-
-            // Handle resource deletion linked to food
-            for index, mapping := range foodPodMappings {
-                if mapping.foodEntity == food {
-                    go deleteResource(mapping.resourceInfo)
-                    deletionMessage := fmt.Sprintf("Oh no! Seems like you ate %s: %s in namespace %s", mapping.resourceInfo.Type, mapping.resourceInfo.Name, mapping.resourceInfo.Namespace)
-                    deletedPodText.SetText(deletionMessage)
-                    log.Println(deletionMessage)
-                    foodPodMappings = append(foodPodMappings[:index], foodPodMappings[index+1:]...)
-                    break
-                }
-            }
-        }
+    // Regurgitate the last eaten resource
+    if event.Type == tl.EventKey && (event.Ch == 'u' || event.Ch == 'U') {
+        go regurgitate()
+    }
 
-        // Grow the snake if needed
-        if snake.growth > 0 {
-            snake.body = append([]Coordinates{newHead}, snake.body...)
-            snake.growth--
-        } else {
-            snake.body = append([]Coordinates{newHead}, snake.body[:len(snake.body)-1]...)
+    // Show messages from work done in the background
+    select {
+    case message := <-hudMessages:
+        showMessage(message)
+    default:
+    }
+
+    input := engine.Input{PlaceFood: pendingFood}
+    pendingFood = nil
+
+    // Food refused by a PodDisruptionBudget bounces back out of the snake
+    select {
+    case bounced := <-bouncedFood:
+        resourceInfo := bounced.resourceInfo
+        if bounced.round == round {
+            input.Bounced++
+            showMessage(fmt.Sprintf("Bounced! A PodDisruptionBudget refused to let you eat %s: %s in namespace %s", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace))
         }
+        select {
+        case resourceInfoQueue <- resourceInfo:
+        default:
+        }
+    default:
+    }
+
+    if event.Type == tl.EventKey {
+        input.Direction = arrowDirections[event.Key]
+    }
+    snake.step(input)
+}
 
-        // Check for collision with walls or self
-        if snake.CollidesWithWalls() || snake.CollidesWithSelf() {
+// step advances the engine and carries out what happened on screen and in the cluster.
+func (snake *Snake) step(input engine.Input) {
+    recorder.recordStep(input)
+    var events []engine.Event
+    gameState, events = engine.Step(gameState, input)
+    score.Store(int64(gameState.Score))
+    scoreText.SetText(fmt.Sprintf("Score: %d", gameState.Score))
+
+    for _, event := range events {
+        switch event.Type {
+        case engine.PauseToggled:
+            if gameState.Paused {
+                phase = phasePaused
+                updatePauseTextPosition()
+            } else {
+                phase = phasePlaying
+                // Move text off-screen when unpaused
+                pauseText.SetPosition(-1, -1)
+            }
+        case engine.FoodEaten:
+            eatFood(event.Food)
+        case engine.GameOver:
             GameOver()
         }
     }
 }
 
+// eatFood deletes the resource linked to the eaten food and tells the player.
+func eatFood(eaten engine.Food) {
+    for index, mapping := range foodPodMappings {
+        if mapping.foodID == eaten.ID {
+            // Captured here, the game loop owns the score
+            mapping.resourceInfo.Score = gameState.Score
+            eating.Add(1)
+            go eatResource(mapping.resourceInfo, round)
+            deletionMessage := fmt.Sprintf("Oh no! Seems like you ate %s: %s in namespace %s", mapping.resourceInfo.Type, mapping.resourceInfo.Name, mapping.resourceInfo.Namespace)
+            if len(clusters) > 1 {
+                deletionMessage += fmt.Sprintf(" on %s", mapping.resourceInfo.Cluster)
+            }
+            if mapping.resourceInfo.EatenVia != "" {
+                deletionMessage += fmt.Sprintf(" (root owner of %s)", mapping.resourceInfo.EatenVia)
+            }
+            if len(mapping.resourceInfo.Owners) > 0 {
+                deletionMessage += fmt.Sprintf(" (owned by %s)", describeOwners(mapping.resourceInfo.Owners))
+            }
+            if dryRun != DryRunNone {
+                deletionMessage += " (dry run)"
+            }
+            showMessage(deletionMessage)
+            log.Println(deletionMessage)
+            foodPodMappings = append(foodPodMappings[:index], foodPodMappings[index+1:]...)
+            break
+        }
+    }
+}
+
 var food *Food
 var game *tl.Game
 var scoreText *tl.Text
 var deletedPodText *tl.Text
-var isPaused bool = false
+var clusterText *tl.Text
+var budgetText *tl.Text
+var hurtingText *tl.Text
 var pauseText *tl.Text
+var titleText *tl.Text
+var finalScoreText *tl.Text
+var restartText *tl.Text
 
-func main() {
-    configFilePath := flag.String("config", "", "Path to configuration file")
-    flag.Parse()
+var (
+    configFilePath string
+    namespaceFlag  string
+)
 
+// addConfigFlags registers the flags that shape the game configuration.
+func addConfigFlags(fs *flag.FlagSet) {
+    fs.StringVar(&configFilePath, "config", "", "Path to configuration file")
+    fs.StringVar(&namespaceFlag, "namespace", "", "Only eat resources in this namespace, overriding the configuration file")
+}
+
+// loadConfig applies the defaults, the configuration file and the flag overrides.
+func loadConfig() {
     setDefaultConfig()
 
     // Load configuration from the specified file if provided
-    if *configFilePath != "" {
-        err := loadConfigFromFile(*configFilePath)
+    if configFilePath != "" {
+        err := loadConfigFromFile(configFilePath)
         if err != nil {
             log.Fatalf("Failed to load config file: %s", err)
         }
     }
 
-    // init k8s client
+    if namespaceFlag != "" {
+        gameConfig.Namespaces.Include = []string{namespaceFlag}
+    }
+}
+
+// connectClusters connects to every configured cluster and resolves the resource types.
+func connectClusters() {
     initKubeClient()
 
-    // Start fetching resources to avoid lag during gameplay
-    go fetchResources()
+    // Resolve custom resources and other group/version/resource types
+    if err := registerDynamicResources(); err != nil {
+        log.Fatalf("Failed to resolve resource types: %s", err)
+    }
+}
+
+// confirmClusters asks for confirmation of each protected cluster in turn before calling start.
+func confirmClusters(protected []*KubeCluster, start func()) {
+    if len(protected) == 0 || dryRun != DryRunNone {
+        start()
+        return
+    }
+    cluster := protected[0]
+    log.Printf("Context %s is protected, asking for confirmation\n", cluster.Context)
+    game.Screen().SetLevel(newConfirmLevel(cluster, func() {
+        if dryRun != DryRunNone {
+            log.Printf("Playing against protected context %s in dry run mode\n", cluster.Context)
+        } else {
+            log.Printf("Confirmed playing against protected context %s\n", cluster.Context)
+        }
+        confirmClusters(protected[1:], start)
+    }))
+}
+
+func main() {
+    if len(os.Args) > 1 {
+        switch os.Args[1] {
+        case "restore":
+            runRestore(os.Args[2:])
+            return
+        case "preflight":
+            runPreflight(os.Args[2:])
+            return
+        case "run":
+            runHeadless(os.Args[2:])
+            return
+        case "generate":
+            runGenerate(os.Args[2:])
+            return
+        case "replay":
+            runReplay(os.Args[2:])
+            return
+        }
+    }
 
-    logFile, err := os.OpenFile("chaos.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+    flag.Var(&dryRun, "dry-run", "Simulate deletions: --dry-run or --dry-run=server sends dry run deletes, --dry-run=client skips the API entirely")
+    sessionBaseDir := flag.String("session-dir", "sessions", "Directory where snapshots of eaten resources are stored")
+    addSeedFlag(flag.CommandLine)
+    addBoardFlag(flag.CommandLine)
+    addConfigFlags(flag.CommandLine)
+    addKubeFlags(flag.CommandLine)
+    addMetricsFlags(flag.CommandLine)
+    addLogFlags(flag.CommandLine, "chaos.log")
+    flag.Parse()
+    checkNoArgs(flag.CommandLine)
+    if err := parseBoardFlag(); err != nil {
+        log.Fatal(err)
+    }
+
+    // Everything from here on, including which cluster is attacked, goes to the log
+    logFile, err := setupLogging()
     if err != nil {
         log.Fatal(err)
     }
-    defer logFile.Close()
+    if logFile != nil {
+        defer logFile.Close()
+    }
+    startMetricsServer()
 
-    log.SetOutput(logFile)
+    loadConfig()
+    connectClusters()
+    for _, cluster := range clusters {
+        log.Printf("Playing against %s\n", cluster)
+    }
+
+    if err := startSession(*sessionBaseDir); err != nil {
+        log.Fatalf("Failed to create session directory: %s", err)
+    }
+
+    // Keep the candidate pool in sync with the cluster
+    if err := startCandidatePool(make(chan struct{})); err != nil {
+        log.Fatalf("Failed to watch resources: %s", err)
+    }
+
+
+    // Queue up food to avoid lag during gameplay, with the seed logged to the log file
+    seedSession()
+    go fetchResources()
+
+    // Probe the steady state before anything gets eaten
+    startHealthChecks()
 
     game = tl.NewGame()
     game.Screen().SetFps(30)
@@ -320,37 +552,53 @@ func main() {
         Ch: ' ',
     })
 
-    snake := NewSnake(20, 20)
-    food = NewFood()
-
-    // Ensure the first food has pod info ready
-    foodX := rand.Intn(LevelWidth-4) + 2
-    foodY := rand.Intn(LevelHeight-4) + 2
-    food.SetPosition(foodX, foodY)
-
-    select {
-    case resourceInfo := <-resourceInfoQueue:
-        foodPodMappings = append(foodPodMappings, FoodPodMapping{
-            foodEntity: food,
-            resourceInfo: resourceInfo,
-        })
-        food.placed = true
-    case <-time.After(10 * time.Second): // Wait up to 10 seconds
-        log.Fatal("Failed to fetch initial pod info in time")
+    // The board fills the terminal, termloop reports resizes once the game runs
+    width, height := boardSize(terminalSize())
+    gameState = engine.NewState(width, height, 20, min(20, height/2))
+    if err := startRecording(gameState); err != nil {
+        log.Fatalf("Failed to start recording: %s", err)
+    }
+    defer stopRecording()
+    // Let deletions in flight finish, with their events, before exiting
+    defer eating.Wait()
+    snake := &Snake{}
+    food = &Food{}
+
+    // Ensure the first food has pod info ready, Food.Tick places it once the health checks pass
+    if clusterHealthy() {
+        select {
+        case resourceInfo := <-resourceInfoQueue:
+            spawnFood(randomFoodPosition(), &resourceInfo)
+        case <-time.After(10 * time.Second): // Wait up to 10 seconds
+            log.Fatal("Failed to fetch initial pod info in time")
+        }
     }
 
     level.AddEntity(snake)
     level.AddEntity(food)
 
     scoreText = tl.NewText(1, 0, "Score: 0", tl.ColorWhite, tl.ColorBlack)
-    deletedPodText = tl.NewText(1, LevelHeight, "", tl.ColorWhite, tl.ColorBlack)
+    deletedPodText = tl.NewText(1, gameState.Height, "", tl.ColorWhite, tl.ColorBlack)
     level.AddEntity(scoreText)
     level.AddEntity(deletedPodText)
 
-	pauseText = tl.NewText(-1, -1, "GAME PAUSED. Press space to RESUME or CTRL+C to QUIT.", tl.ColorWhite, tl.ColorBlack)
-	game.Screen().AddEntity(pauseText)
+    // Always show which cluster is under attack
+    clusterText = tl.NewText(1, gameState.Height+1, fmt.Sprintf("Attacking %s", describeClusters()), tl.ColorRed, tl.ColorBlack)
+    level.AddEntity(clusterText)
+
+    budgetText = tl.NewText(15, 0, budget.Summary(), tl.ColorYellow, tl.ColorBlack)
+    level.AddEntity(budgetText)
+
+    hurtingText = tl.NewText(-1, -1, "", tl.ColorRed, tl.ColorBlack)
+    level.AddEntity(hurtingText)
 
-    game.Screen().SetLevel(level)
+    newOverlays(level, "Press R to RESTART or CTRL+C to QUIT")
+    showTitleScreen()
+
+    // Protected clusters need the player to type the cluster name first
+    confirmClusters(protectedClusters(), func() {
+        game.Screen().SetLevel(level)
+    })
     game.Start()
 }
 
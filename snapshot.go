@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Snapshot is the manifest of an eaten resource, stored so it can be restored later.
+type Snapshot struct {
+    Context  string                     `json:"context"`
+    Group    string                     `json:"group"`
+    Version  string                     `json:"version"`
+    Resource string                     `json:"resource"`
+    Object   *unstructured.Unstructured `json:"object"`
+}
+
+var (
+    sessionDir      string
+    snapshotMutex   sync.Mutex
+    snapshotCounter int
+)
+
+// startSession creates a fresh directory below baseDir for this session's snapshots.
+func startSession(baseDir string) error {
+    sessionDir = filepath.Join(baseDir, time.Now().Format("20060102-150405"))
+    return os.MkdirAll(sessionDir, 0755)
+}
+
+// sessionID identifies the current session, empty before one is started.
+func sessionID() string {
+    if sessionDir == "" {
+        return ""
+    }
+    return filepath.Base(sessionDir)
+}
+
+// snapshotResource fetches the resource and writes its manifest to the session directory,
+// returning the path written. Resources owned by a controller are skipped, since the
+// controller recreates them anyway, and the path is empty.
+func snapshotResource(cluster *KubeCluster, resourceInfo ResourceInfo) (string, error) {
+    gvr, err := getResourceGVR(resourceInfo.Type)
+    if err != nil {
+        return "", err
+    }
+
+    obj, err := cluster.dynamicClient.Resource(gvr).Namespace(resourceInfo.Namespace).Get(context.TODO(), resourceInfo.Name, metav1.GetOptions{})
+    if apierrors.IsNotFound(err) || (err == nil && resourceInfo.UID != "" && obj.GetUID() != resourceInfo.UID) {
+        return "", fmt.Errorf("%w: %s %s in namespace %s no longer exists", errFoodGoneOff, resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace)
+    }
+    if err != nil {
+        return "", err
+    }
+    if metav1.GetControllerOf(obj) != nil {
+        log.Printf("Not snapshotting %s %s in namespace %s on %s, it is owned by a controller\n", resourceInfo.Type, resourceInfo.Name, resourceInfo.Namespace, cluster.Context)
+        return "", nil
+    }
+
+    // Strip everything the API server sets so the manifest can be created again
+    unstructured.RemoveNestedField(obj.Object, "status")
+    for _, field := range []string{"resourceVersion", "uid", "managedFields", "creationTimestamp", "generation", "selfLink"} {
+        unstructured.RemoveNestedField(obj.Object, "metadata", field)
+    }
+
+    data, err := json.MarshalIndent(Snapshot{Context: cluster.Context, Group: gvr.Group, Version: gvr.Version, Resource: gvr.Resource, Object: obj}, "", "  ")
+    if err != nil {
+        return "", err
+    }
+
+    snapshotMutex.Lock()
+    defer snapshotMutex.Unlock()
+    snapshotCounter++
+    path := filepath.Join(sessionDir, fmt.Sprintf("%04d-%s-%s-%s.json", snapshotCounter, resourceInfo.Type, resourceInfo.Namespace, resourceInfo.Name))
+    return path, os.WriteFile(path, data, 0600)
+}
+
+// pendingSnapshots returns the snapshots in dir that have not been restored yet, oldest first.
+func pendingSnapshots(dir string) ([]string, error) {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, err
+    }
+    var files []string
+    for _, entry := range entries {
+        if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+            files = append(files, filepath.Join(dir, entry.Name()))
+        }
+    }
+    sort.Strings(files)
+    return files, nil
+}
+
+// restoreSnapshots recreates the last n snapshots in dir, newest first. A count of 0 restores all of them.
+// Snapshots of resources that exist again are skipped and do not count.
+func restoreSnapshots(dir string, count int) ([]string, error) {
+    snapshotMutex.Lock()
+    defer snapshotMutex.Unlock()
+
+    files, err := pendingSnapshots(dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var restored []string
+    for i := len(files) - 1; i >= 0 && (count == 0 || len(restored) < count); i-- {
+        name, err := restoreSnapshot(files[i])
+        if apierrors.IsAlreadyExists(err) {
+            // Set aside so it is never tried again
+            log.Printf("Skipping %s, it already exists\n", filepath.Base(files[i]))
+            if err := os.Rename(files[i], files[i]+".exists"); err != nil {
+                return restored, err
+            }
+            continue
+        }
+        if err != nil {
+            return restored, fmt.Errorf("restoring %s: %w", filepath.Base(files[i]), err)
+        }
+        restored = append(restored, name)
+    }
+    return restored, nil
+}
+
+func restoreSnapshot(path string) (string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "", err
+    }
+    var snapshot Snapshot
+    if err := json.Unmarshal(data, &snapshot); err != nil {
+        return "", err
+    }
+
+    cluster, err := getCluster(snapshot.Context)
+    if err != nil {
+        return "", err
+    }
+    gvr := schema.GroupVersionResource{Group: snapshot.Group, Version: snapshot.Version, Resource: snapshot.Resource}
+    obj := snapshot.Object
+    _, err = cluster.dynamicClient.Resource(gvr).Namespace(obj.GetNamespace()).Create(context.TODO(), obj, metav1.CreateOptions{})
+    if err != nil {
+        return "", err
+    }
+
+    // Keep the file around but make sure it is never restored twice
+    if err := os.Rename(path, path+".restored"); err != nil {
+        return "", err
+    }
+    name := fmt.Sprintf("%s %s in namespace %s on %s", strings.ToLower(obj.GetKind()), obj.GetName(), obj.GetNamespace(), cluster.Context)
+    log.Printf("Restored %s\n", name)
+    return name, nil
+}
+
+// latestSession returns the most recent session directory below baseDir.
+func latestSession(baseDir string) (string, error) {
+    entries, err := os.ReadDir(baseDir)
+    if err != nil {
+        return "", err
+    }
+    var sessions []string
+    for _, entry := range entries {
+        if entry.IsDir() {
+            sessions = append(sessions, entry.Name())
+        }
+    }
+    if len(sessions) == 0 {
+        return "", fmt.Errorf("no sessions found in %s", baseDir)
+    }
+    sort.Strings(sessions)
+    return filepath.Join(baseDir, sessions[len(sessions)-1]), nil
+}
+
+// runRestore implements the "serpent restore" subcommand.
+func runRestore(args []string) {
+    restoreFlags := flag.NewFlagSet("restore", flag.ExitOnError)
+    baseDir := restoreFlags.String("session-dir", "sessions", "Directory holding session snapshots")
+    session := restoreFlags.String("session", "", "Session to restore from (defaults to the latest)")
+    count := restoreFlags.Int("n", 0, "Number of most recently eaten resources to restore (0 restores all)")
+    addKubeFlags(restoreFlags)
+    restoreFlags.Parse(args)
+
+    dir := filepath.Join(*baseDir, *session)
+    if *session == "" {
+        var err error
+        dir, err = latestSession(*baseDir)
+        if err != nil {
+            log.Fatalf("Failed to find a session: %s", err)
+        }
+    }
+
+    initKubeClient()
+
+    restored, err := restoreSnapshots(dir, *count)
+    for _, name := range restored {
+        fmt.Printf("Restored %s\n", name)
+    }
+    if err != nil {
+        log.Fatalf("Failed to restore: %s", err)
+    }
+    if len(restored) == 0 {
+        fmt.Printf("Nothing left to restore in %s\n", dir)
+    }
+}